@@ -0,0 +1,148 @@
+package rudp
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// With Config.BatchInterval set, messages that fit in a packet are not sent at once but
+// held for up to BatchInterval and packed into one BATCH datagram with the messages sent
+// in the meantime, so a tick's worth of input, chat and events pays for one header
+// instead of one per message. Reliable and unreliable messages are batched separately;
+// a reliable batch is acked and retransmitted as a unit. Each message keeps its own mode,
+// channel and order sequence, and batches are unpacked before delivery. Send refuses
+// unreliable messages with ErrBufferFull while the outbound buffer is full, as it does
+// without batching; if the buffer filled up again by the time their batch is sent, the
+// batch is dropped like any unreliable packet and counted in Stats.OutboundDrops.
+const batchEntryHeaderSize = 6 // Mode(1) + Channel(1) + OrderSeq(2) + Size(2)
+
+// batchable reports whether a message should be batched rather than sent on its own
+func (c *Connection) batchable(message *Packet) bool {
+	return c.config.BatchInterval > 0 && batchEntryHeaderSize+len(message.Data) <= c.maxPayload()
+}
+
+// addToBatch adds a message to the batch of its reliability, sending the batch first if
+// the message does not fit. Must be called with the lock held.
+func (c *Connection) addToBatch(message *Packet) error {
+	index := 0
+	if message.IsReliable() {
+		if len(c.backlog) >= c.config.OutboundBufferSize {
+			return ErrBufferFull
+		}
+		index = 1
+	} else if len(c.outbound) >= cap(c.outbound) {
+		return ErrBufferFull
+	}
+
+	if message.receipt != nil {
+		message.receipt.remaining = 1
+	}
+	c.assignOrderSequence(message)
+
+	batch := c.batches[index]
+	if batch != nil && len(batch.Data)+batchEntryHeaderSize+len(message.Data) > c.maxPayload() {
+		c.sendBatch(index)
+		batch = nil
+	}
+	if batch == nil {
+		if c.batches[0] == nil && c.batches[1] == nil {
+			c.scheduleBatch()
+		}
+		batch = &Packet{
+			Type:      BATCH,
+			ClientID:  c.clientID,
+			Mode:      Unreliable,
+			Timestamp: time.Now().UnixNano(),
+		}
+		if message.IsReliable() {
+			batch.Mode = Reliable
+		}
+		c.batches[index] = batch
+	}
+
+	batch.Data = append(batch.Data, byte(message.Mode), message.Channel)
+	batch.Data = binary.LittleEndian.AppendUint16(batch.Data, message.OrderSequence)
+	batch.Data = binary.LittleEndian.AppendUint16(batch.Data, uint16(len(message.Data)))
+	batch.Data = append(batch.Data, message.Data...)
+	batch.batch = append(batch.batch, message)
+	return nil
+}
+
+// scheduleBatch arranges for the batches to be sent after BatchInterval.
+// Must be called with the lock held.
+func (c *Connection) scheduleBatch() {
+	if c.batchTimer == nil {
+		c.batchTimer = time.AfterFunc(c.config.BatchInterval, c.Flush)
+	} else {
+		c.batchTimer.Reset(c.config.BatchInterval)
+	}
+}
+
+// sendBatch sends a batch, as a plain DATA packet if it holds a single message. An
+// unreliable batch finding the outbound buffer full is dropped and its messages counted.
+// Must be called with the lock held.
+func (c *Connection) sendBatch(index int) {
+	batch := c.batches[index]
+	c.batches[index] = nil
+
+	packet := batch
+	if len(batch.batch) == 1 {
+		packet = batch.batch[0]
+	}
+	if !packet.IsReliable() && len(c.outbound) >= cap(c.outbound) {
+		c.counters.outboundDrops.Add(uint64(len(batch.batch)))
+		return
+	}
+	c.queue(packet)
+}
+
+// Flush sends batched messages right away instead of waiting for BatchInterval,
+// for example at the end of a game tick
+func (c *Connection) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushBatches()
+}
+
+// flushBatches sends the batches being filled. Must be called with the lock held.
+func (c *Connection) flushBatches() {
+	if c.closed {
+		return
+	}
+	for index, batch := range c.batches {
+		if batch != nil {
+			c.sendBatch(index)
+		}
+	}
+}
+
+// unbatch unpacks the messages of a BATCH packet
+func (c *Connection) unbatch(packet *Packet) ([]*Packet, error) {
+	var messages []*Packet
+	data := packet.Data
+	for len(data) > 0 {
+		if len(data) < batchEntryHeaderSize {
+			return nil, ErrInvalidPacket
+		}
+		size := int(binary.LittleEndian.Uint16(data[4:6]))
+		if len(data) < batchEntryHeaderSize+size {
+			return nil, ErrInvalidPacket
+		}
+		message := &Packet{
+			Type:          DATA,
+			ClientID:      packet.ClientID,
+			Sequence:      packet.Sequence,
+			Mode:          DeliveryMode(data[0]),
+			Channel:       data[1],
+			OrderSequence: binary.LittleEndian.Uint16(data[2:4]),
+			Data:          data[batchEntryHeaderSize : batchEntryHeaderSize+size],
+			Timestamp:     packet.Timestamp,
+		}
+		if int(message.Channel) >= c.config.Channels {
+			return nil, ErrInvalidChannel
+		}
+		messages = append(messages, message)
+		data = data[batchEntryHeaderSize+size:]
+	}
+	return messages, nil
+}
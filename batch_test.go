@@ -0,0 +1,116 @@
+package rudp
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// batchEntry serializes one message of a BATCH packet
+func batchEntry(mode DeliveryMode, channel uint8, orderSequence uint16, size int, data []byte) []byte {
+	buf := []byte{byte(mode), channel}
+	buf = binary.LittleEndian.AppendUint16(buf, orderSequence)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(size))
+	return append(buf, data...)
+}
+
+func TestBatchRoundTrip(t *testing.T) {
+	config := DefaultConfig()
+	config.BatchInterval = time.Hour
+	config.Channels = 2
+	c := newTestConnection(config)
+
+	messages := []*Packet{
+		{Type: DATA, Mode: Reliable, Data: []byte("first")},
+		{Type: DATA, Mode: ReliableOrdered, Channel: 1, Data: []byte("second")},
+		{Type: DATA, Mode: Reliable, Data: nil},
+	}
+	c.mu.Lock()
+	for _, message := range messages {
+		if err := c.addToBatch(message); err != nil {
+			t.Fatalf("addToBatch() error = %v", err)
+		}
+	}
+	batch := c.batches[1]
+	c.batchTimer.Stop()
+	c.mu.Unlock()
+
+	got, err := c.unbatch(batch)
+	if err != nil {
+		t.Fatalf("unbatch() error = %v", err)
+	}
+	if len(got) != len(messages) {
+		t.Fatalf("unbatched %d messages, want %d", len(got), len(messages))
+	}
+	for i, message := range messages {
+		if got[i].Mode != message.Mode || got[i].Channel != message.Channel ||
+			got[i].OrderSequence != message.OrderSequence || string(got[i].Data) != string(message.Data) {
+			t.Errorf("message %d = %+v, want %+v", i, got[i], message)
+		}
+	}
+}
+
+func TestUnbatchRejects(t *testing.T) {
+	valid := batchEntry(Reliable, 0, 0, 3, []byte("abc"))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"truncated entry header", append(append([]byte{}, valid...), 2, 0, 0), ErrInvalidPacket},
+		{"size past end", batchEntry(Reliable, 0, 0, 4, []byte("abc")), ErrInvalidPacket},
+		{"size past end of later entry", append(append([]byte{}, valid...), batchEntry(Reliable, 0, 0, 100, nil)...), ErrInvalidPacket},
+		{"invalid channel", append(append([]byte{}, valid...), batchEntry(Reliable, 2, 0, 1, []byte("x"))...), ErrInvalidChannel},
+	}
+
+	config := DefaultConfig()
+	config.Channels = 2
+	c := newTestConnection(config)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := c.unbatch(&Packet{Type: BATCH, Mode: Reliable, Data: tt.data})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("unbatch() error = %v, want %v", err, tt.wantErr)
+			}
+			if messages != nil {
+				t.Errorf("unbatch() returned %d messages along with an error", len(messages))
+			}
+		})
+	}
+}
+
+func TestBatchOutboundFull(t *testing.T) {
+	config := DefaultConfig()
+	config.BatchInterval = time.Hour
+	config.OutboundBufferSize = 1
+	c := newTestConnection(config)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Refused like an unbatched message while the buffer is full
+	c.queueControlLocked(PING, nil)
+	if err := c.addToBatch(&Packet{Type: DATA, Mode: Unreliable, Data: []byte("a")}); !errors.Is(err, ErrBufferFull) {
+		t.Errorf("addToBatch() with a full buffer error = %v, want %v", err, ErrBufferFull)
+	}
+	drainOutbound(c)
+
+	for _, data := range []string{"b", "c"} {
+		if err := c.addToBatch(&Packet{Type: DATA, Mode: Unreliable, Data: []byte(data)}); err != nil {
+			t.Fatalf("addToBatch() error = %v", err)
+		}
+	}
+	c.batchTimer.Stop()
+
+	// The buffer filled up again before the batch was sent, so it is dropped and counted
+	c.queueControlLocked(PING, nil)
+	c.flushBatches()
+	if c.batches[0] != nil {
+		t.Error("batch still being filled after flushing")
+	}
+	if drops := c.counters.outboundDrops.Load(); drops != 2 {
+		t.Errorf("outboundDrops = %d, want the 2 batched messages", drops)
+	}
+}
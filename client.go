@@ -1,11 +1,17 @@
 package rudp
 
 import (
+	"crypto/ecdh"
 	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/cbodonnell/rudp/token"
 )
 
 // Client represents a UDP client connection
@@ -14,19 +20,48 @@ type Client struct {
 	connection *Connection
 	clientID   uint32
 	connected  bool
+	config     Config
+	dropped    atomic.Uint64
 
-	// Events
-	OnMessage    func(*Packet)
-	OnDisconnect func()
+	// Connect token, if the client was given one by an issuer
+	connectToken []byte
+	tokenKey     []byte
 
-	done chan struct{}
+	// Reconnection
+	reconnecting     atomic.Bool
+	handshakeReplies chan *Packet // Handshake packets received while reconnecting
+
+	// Events
+	OnMessage        func(*Packet)
+	OnDisconnect     func(DisconnectReason)
+	OnDeliveryFailed func(*Packet)
+	OnReconnecting   func(attempt int) // Called before each attempt to resume a suspended connection
+	OnReconnected    func()            // Called when a suspended connection was resumed
+
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-// NewClient creates a new UDP client
+// NewClient creates a new UDP client using the default configuration
 func NewClient() *Client {
+	return newClient(DefaultConfig())
+}
+
+// NewClientWithConfig creates a new UDP client using the given configuration
+func NewClientWithConfig(config Config) (*Client, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return newClient(config), nil
+}
+
+// newClient creates a client from an already validated config
+func newClient(config Config) *Client {
 	return &Client{
-		clientID: generateClientID(),
-		done:     make(chan struct{}),
+		clientID:         generateClientID(),
+		config:           config.withDefaults(),
+		handshakeReplies: make(chan *Packet, handshakeReplyBuffer),
+		done:             make(chan struct{}),
 	}
 }
 
@@ -37,54 +72,118 @@ func generateClientID() uint32 {
 	return binary.LittleEndian.Uint32(b)
 }
 
-// Connect establishes a connection to the server
+// Connect establishes a connection to the server. If the server refuses the
+// connection the error is a *RejectedError carrying its reason.
 func (c *Client) Connect(addr string) error {
+	_, err := c.ConnectWithPayload(addr, nil)
+	return err
+}
+
+// ConnectWithPayload establishes a connection to the server, sending application data
+// (such as a player name or game version) to its OnConnectRequest hook, and returns
+// the payload the hook replied with.
+func (c *Client) ConnectWithPayload(addr string, payload []byte) ([]byte, error) {
+	if c.config.overhead()+c.connectResponseSize(payload) > c.config.MaxPacketSize {
+		return nil, ErrPacketTooLarge
+	}
+
 	serverAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	c.conn, err = net.ListenUDP("udp", nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	c.connection = NewConnection(c.conn, serverAddr, c.clientID)
+	c.connection = newConnection(c.conn, serverAddr, c.clientID, c.config)
+	c.connection.onDeliveryFailed = func(packet *Packet) {
+		if c.OnDeliveryFailed != nil {
+			c.OnDeliveryFailed(packet)
+		}
+	}
+	c.connection.onSuspend = func() {
+		go c.reconnect()
+	}
+	c.connection.onResume = func() {
+		if c.OnReconnected != nil {
+			c.OnReconnected()
+		}
+	}
 
 	// Perform handshake BEFORE starting background goroutines
-	if err := c.performHandshake(); err != nil {
-		c.Close()
-		return err
+	if err := c.performHandshake(payload); err != nil {
+		c.connection.shutdown(DisconnectNone, nil)
+		c.release()
+		return nil, err
 	}
 
 	// Now start packet processing
+	c.connection.start()
 	go c.handlePackets()
 	go c.handleConnection()
 
-	return nil
+	return c.connection.HandshakePayload(), nil
 }
 
-// performHandshake sends CONNECT and waits for CONNECT_ACK
-func (c *Client) performHandshake() error {
-	connectPacket := &Packet{
-		Type:     CONNECT,
-		ClientID: c.clientID,
-		Data:     []byte{},
+// ConnectWithToken establishes a connection to a server that requires connect tokens.
+// The sealed token and its session key come from the token issuer; the session key
+// never leaves the client, which only proves it holds it. Connections made with a
+// token are always secure.
+func (c *Client) ConnectWithToken(addr string, connectToken []byte, sessionKey []byte) error {
+	if len(sessionKey) != token.SessionKeySize {
+		return token.ErrInvalidSession
+	}
+	c.connectToken = connectToken
+	c.tokenKey = sessionKey
+	return c.Connect(addr)
+}
+
+// connectResponseSize returns the size of the CONNECT_RESPONSE the handshake will send,
+// so a token or payload too large for it is reported before anything is sent
+func (c *Client) connectResponseSize(payload []byte) int {
+	response := &connectResponse{cookie: make([]byte, cookieSize), token: c.connectToken, payload: payload}
+	if c.config.secure() || c.connectToken != nil {
+		response.publicKey = make([]byte, publicKeySize)
 	}
+	if c.connectToken != nil {
+		response.proof = make([]byte, cookieMACSize)
+	}
+	return len(response.marshal())
+}
 
-	// Send CONNECT packet
-	data := connectPacket.Marshal()
-	_, err := c.conn.WriteToUDP(data, c.connection.RemoteAddr())
-	if err != nil {
-		return err
+// performHandshake sends CONNECT, answers the server's CONNECT_CHALLENGE with a
+// CONNECT_RESPONSE echoing its cookie, and waits for CONNECT_ACK. Unanswered
+// handshake packets are resent until HandshakeTimeout. Only the first challenge
+// is answered, since the session is keyed from the cookie the server accepts.
+func (c *Client) performHandshake(payload []byte) error {
+	request := c.connectRequest()
+
+	// In secure mode the client's public key is sent along with the cookie
+	var private *ecdh.PrivateKey
+	var cookie []byte
+	if c.config.secure() || c.connectToken != nil {
+		var err error
+		if private, err = newKeyPair(); err != nil {
+			return err
+		}
 	}
 
-	// Wait for CONNECT_ACK with timeout (no other goroutines reading yet)
-	buffer := make([]byte, MaxPacketSize)
-	deadline := time.Now().Add(5 * time.Second)
+	// Wait for replies with timeout (no other goroutines reading yet)
+	buffer := make([]byte, c.config.MaxPacketSize)
+	deadline := time.Now().Add(c.config.HandshakeTimeout)
+	var lastSent time.Time
 
 	for time.Now().Before(deadline) {
-		c.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if time.Since(lastSent) >= handshakeInterval {
+			if _, err := c.conn.WriteToUDP(c.config.marshal(request), c.connection.RemoteAddr()); err != nil {
+				return err
+			}
+			lastSent = time.Now()
+		}
+
+		c.conn.SetReadDeadline(time.Now().Add(handshakePoll))
 		n, _, err := c.conn.ReadFromUDP(buffer)
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
@@ -94,24 +193,93 @@ func (c *Client) performHandshake() error {
 		}
 
 		packet := &Packet{}
-		if err := packet.Unmarshal(buffer[:n]); err != nil {
+		if err := c.config.unmarshal(packet, buffer[:n]); err != nil {
+			c.dropped.Add(1)
+			if errors.Is(err, ErrVersionMismatch) && packet.ClientID == c.clientID {
+				return fmt.Errorf("handshake: %w: server speaks version %d, client speaks version %d", err, packetVersion(buffer[:n]), ProtocolVersion)
+			}
 			fmt.Printf("Failed to unmarshal packet during handshake: %v\n", err)
 			continue
 		}
+		if c.config.Checksum && !packet.checksummed {
+			c.dropped.Add(1)
+			continue
+		}
+		if packet.ClientID != c.clientID {
+			continue
+		}
 
-		if packet.Type == CONNECT_ACK && packet.ClientID == c.clientID {
+		switch packet.Type {
+		case CONNECT_CHALLENGE:
+			// Challenges to resent or duplicated CONNECTs are ignored once answered
+			if cookie != nil {
+				continue
+			}
+
+			// Answer immediately, then keep resending the response instead of CONNECT
+			cookie = packet.Data
+			response := &connectResponse{cookie: cookie, token: c.connectToken, payload: payload}
+			if private != nil {
+				response.publicKey = private.PublicKey().Bytes()
+			}
+			if c.connectToken != nil {
+				response.proof = tokenProof(c.tokenKey, response)
+			}
+			request = &Packet{
+				Type:     CONNECT_RESPONSE,
+				ClientID: c.clientID,
+				Data:     response.marshal(),
+			}
+			if c.config.overhead()+len(request.Data) > c.config.MaxPacketSize {
+				return ErrPacketTooLarge
+			}
+			lastSent = time.Time{}
+		case CONNECT_REJECT:
+			return &RejectedError{Reason: rejectReason(packet)}
+		case CONNECT_ACK:
+			ack, err := parseConnectAck(packet.Data)
+			if err != nil {
+				continue
+			}
+			if private != nil {
+				if cookie == nil {
+					continue
+				}
+				sess, err := newSession(private, ack.publicKey, cookie, c.tokenKey, true)
+				if err != nil {
+					return err
+				}
+				c.connection.session = sess
+			}
+			c.connection.handshakePayload = ack.payload
+			c.connection.pathKey = connectionKey(c.connection.session, ack.token, pathKeyLabel)
+			if c.config.ResumeGracePeriod > 0 {
+				c.connection.resumeKey = connectionKey(c.connection.session, ack.token, resumeKeyLabel)
+			}
 			c.connected = true
 			fmt.Printf("Connected to server with clientID: %d\n", c.clientID)
 			return nil
 		}
 	}
 
-	return fmt.Errorf("handshake timeout: no CONNECT_ACK received")
+	return fmt.Errorf("handshake: %w: no CONNECT_ACK received", ErrTimeout)
+}
+
+// connectRequest builds the CONNECT packet starting a handshake. It carries the AppID
+// and is padded so the server's challenge is never larger than the request.
+func (c *Client) connectRequest() *Packet {
+	request := &Packet{
+		Type:     CONNECT,
+		ClientID: c.clientID,
+		Data:     make([]byte, connectPadding),
+	}
+	binary.LittleEndian.PutUint32(request.Data, c.config.AppID)
+	return request
 }
 
 // handlePackets reads incoming UDP packets
 func (c *Client) handlePackets() {
-	buffer := make([]byte, MaxPacketSize)
+	buffer := make([]byte, c.config.MaxPacketSize)
 
 	for {
 		select {
@@ -129,16 +297,26 @@ func (c *Client) handlePackets() {
 
 			// Parse packet to check type
 			packet := &Packet{}
-			if err := packet.Unmarshal(buffer[:n]); err != nil {
+			if err := c.config.unmarshal(packet, buffer[:n]); err != nil {
+				c.dropped.Add(1)
 				continue
 			}
 
-			// Ignore handshake packets (already handled during Connect)
-			if packet.Type == CONNECT || packet.Type == CONNECT_ACK {
+			// Handshake packets only matter while reconnecting
+			if packet.Type == CONNECT_ACK || packet.Type == CONNECT_CHALLENGE || packet.Type == CONNECT_REJECT {
+				if packet.ClientID == c.clientID && (!c.config.Checksum || packet.checksummed) {
+					select {
+					case c.handshakeReplies <- packet:
+					default:
+					}
+				}
 				continue
 			}
 
 			if err := c.connection.HandleIncomingPacket(packet); err != nil {
+				if errors.Is(err, ErrChecksumMismatch) {
+					c.dropped.Add(1)
+				}
 				// TODO: log the error
 				continue
 			}
@@ -159,9 +337,11 @@ func (c *Client) handleConnection() {
 		}
 	}
 
-	// Connection closed
+	// Connection closed, either locally or by the server
+	c.release()
+
 	if c.OnDisconnect != nil {
-		c.OnDisconnect()
+		c.OnDisconnect(c.connection.DisconnectReason())
 	}
 }
 
@@ -173,28 +353,143 @@ func (c *Client) Send(data []byte, mode DeliveryMode) error {
 	return c.connection.Send(data, mode)
 }
 
+// SendOnChannel transmits data to the server on the given channel
+func (c *Client) SendOnChannel(channel uint8, data []byte, mode DeliveryMode) error {
+	if c.connection == nil {
+		return ErrConnectionClosed
+	}
+	return c.connection.SendOnChannel(channel, data, mode)
+}
+
+// SendWithReceipt transmits reliable data to the server and returns a receipt
+// that resolves once the server acknowledges it or retransmissions are exhausted
+func (c *Client) SendWithReceipt(data []byte, mode DeliveryMode) (*Receipt, error) {
+	if c.connection == nil {
+		return nil, ErrConnectionClosed
+	}
+	return c.connection.SendWithReceipt(data, mode)
+}
+
+// SendOnChannelWithReceipt is SendWithReceipt on the given channel
+func (c *Client) SendOnChannelWithReceipt(channel uint8, data []byte, mode DeliveryMode) (*Receipt, error) {
+	if c.connection == nil {
+		return nil, ErrConnectionClosed
+	}
+	return c.connection.SendOnChannelWithReceipt(channel, data, mode)
+}
+
 // IsConnected returns true if connected to server
 func (c *Client) IsConnected() bool {
 	return c.connected && c.connection != nil && c.connection.IsConnected()
 }
 
+// RTT returns the smoothed round-trip time to the server
+func (c *Client) RTT() time.Duration {
+	if c.connection == nil {
+		return 0
+	}
+	return c.connection.RTT()
+}
+
+// RTTVar returns the round-trip time variation to the server
+func (c *Client) RTTVar() time.Duration {
+	if c.connection == nil {
+		return 0
+	}
+	return c.connection.RTTVar()
+}
+
+// Flush sends batched messages to the server right away instead of waiting for BatchInterval
+func (c *Client) Flush() {
+	if c.connection != nil {
+		c.connection.Flush()
+	}
+}
+
+// MTU returns the largest datagram sent to the server
+func (c *Client) MTU() int {
+	if c.connection == nil {
+		return 0
+	}
+	return c.connection.MTU()
+}
+
+// Stats returns a snapshot of how the connection to the server is performing
+func (c *Client) Stats() Stats {
+	if c.connection == nil {
+		return Stats{}
+	}
+	return c.connection.Stats()
+}
+
+// QueuedBytes returns the bytes waiting to be sent to the server
+func (c *Client) QueuedBytes() int {
+	if c.connection == nil {
+		return 0
+	}
+	return c.connection.QueuedBytes()
+}
+
+// CongestionWindow returns how many reliable packets may currently be in flight to the server
+func (c *Client) CongestionWindow() int {
+	if c.connection == nil {
+		return 0
+	}
+	return c.connection.CongestionWindow()
+}
+
 // ClientID returns the client's unique identifier
 func (c *Client) ClientID() uint32 {
 	return c.clientID
 }
 
+// DroppedPackets returns the number of datagrams dropped because they were corrupted,
+// failed their checksum or were not RUDP packets of this protocol version
+func (c *Client) DroppedPackets() uint64 {
+	return c.dropped.Load()
+}
+
 // Close disconnects from the server
 func (c *Client) Close() error {
-	close(c.done)
+	return c.CloseWithReason(DisconnectRequested, nil)
+}
 
+// CloseWithReason disconnects from the server, sending it the reason and optional payload
+func (c *Client) CloseWithReason(reason DisconnectReason, payload []byte) error {
 	if c.connection != nil {
-		c.connection.Close()
+		if err := c.connection.CloseWithReason(reason, payload); err != nil {
+			return err
+		}
 	}
+	return c.release()
+}
 
-	if c.conn != nil {
-		return c.conn.Close()
+// DisconnectReason returns why the client was disconnected, or DisconnectNone while connected
+func (c *Client) DisconnectReason() DisconnectReason {
+	if c.connection == nil {
+		return DisconnectNone
 	}
-	return nil
+	return c.connection.DisconnectReason()
+}
+
+// DisconnectPayload returns the payload the server sent along with the disconnect, if any
+func (c *Client) DisconnectPayload() []byte {
+	if c.connection == nil {
+		return nil
+	}
+	return c.connection.DisconnectPayload()
+}
+
+// release stops the client's background goroutines and closes its socket
+func (c *Client) release() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if c.conn != nil {
+			err = c.conn.Close()
+		}
+	})
+	return err
 }
 
 // RemoteAddr returns the server address
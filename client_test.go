@@ -0,0 +1,60 @@
+package rudp
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cbodonnell/rudp/token"
+)
+
+func TestConnectResponseFitsLargestToken(t *testing.T) {
+	c := NewClient()
+	c.connectToken = make([]byte, token.MaxSize)
+	c.tokenKey = make([]byte, token.SessionKeySize)
+
+	if size := c.config.overhead() + c.connectResponseSize(nil); size > DefaultMaxPacketSize {
+		t.Errorf("CONNECT_RESPONSE of %d bytes exceeds DefaultMaxPacketSize %d", size, DefaultMaxPacketSize)
+	}
+}
+
+func TestConnectWithTokenTooLarge(t *testing.T) {
+	c := NewClient()
+	err := c.ConnectWithToken("127.0.0.1:9", make([]byte, DefaultMaxPacketSize), make([]byte, token.SessionKeySize))
+	if !errors.Is(err, ErrPacketTooLarge) {
+		t.Errorf("ConnectWithToken() error = %v, want %v", err, ErrPacketTooLarge)
+	}
+	// Nothing is sent, so not even a socket is opened
+	if c.conn != nil {
+		t.Error("ConnectWithToken() opened a socket")
+	}
+}
+
+func TestClientSendOnChannelWithReceipt(t *testing.T) {
+	s := NewServer()
+	received := make(chan *Packet, 1)
+	s.OnMessage = func(_ *Connection, packet *Packet) { received <- packet }
+	addr := listenTestServer(t, s)
+
+	c := NewClient()
+	if _, err := c.SendOnChannelWithReceipt(3, []byte("early"), Reliable); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("SendOnChannelWithReceipt() before connecting error = %v, want %v", err, ErrConnectionClosed)
+	}
+	if err := c.Connect(addr); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.SendOnChannelWithReceipt(3, []byte("state"), Unreliable); !errors.Is(err, ErrNotReliable) {
+		t.Errorf("SendOnChannelWithReceipt() unreliable error = %v, want %v", err, ErrNotReliable)
+	}
+	receipt, err := c.SendOnChannelWithReceipt(3, []byte("state"), ReliableOrdered)
+	if err != nil {
+		t.Fatalf("SendOnChannelWithReceipt() error = %v", err)
+	}
+	if status := receipt.Wait(); status != DeliveryDelivered {
+		t.Errorf("receipt = %v, want %v", status, DeliveryDelivered)
+	}
+	if packet := <-received; packet.Channel != 3 || string(packet.Data) != "state" {
+		t.Errorf("server received %q on channel %d, want %q on channel 3", packet.Data, packet.Channel, "state")
+	}
+}
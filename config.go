@@ -0,0 +1,311 @@
+package rudp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cbodonnell/rudp/token"
+)
+
+// Default configuration values
+const (
+	DefaultMaxPacketSize            = 1400 // bytes
+	DefaultRetransmissionTimeout    = 100 * time.Millisecond
+	DefaultMinRetransmissionTimeout = 20 * time.Millisecond
+	DefaultMaxRetransmissionTimeout = 2 * time.Second
+	DefaultMaxRetransmissions       = 5
+	DefaultInactivityTimeout        = 5 * time.Second
+	DefaultHeartbeatInterval        = 1 * time.Second
+	DefaultAckDelay                 = 10 * time.Millisecond
+	DefaultHandshakeTimeout         = 5 * time.Second
+	DefaultBufferSize               = 256 // packets
+	DefaultChannels                 = 16
+	DefaultMaxFragments             = 64
+	DefaultFragmentTimeout          = 5 * time.Second
+	DefaultMaxReassemblyBytes       = 1 << 20 // bytes
+	DefaultReconnectBackoff         = 250 * time.Millisecond
+	DefaultMaxReconnectBackoff      = 4 * time.Second
+	DefaultMigrationInterval        = 1 * time.Second
+	DefaultMTUProbeInterval         = 1 * time.Minute
+)
+
+// Former fixed settings, kept so existing callers still compile
+const (
+	// Deprecated: Use DefaultMaxPacketSize, or Config.MaxPacketSize to change it.
+	MaxPacketSize = DefaultMaxPacketSize
+	// Deprecated: Use DefaultRetransmissionTimeout, or Config.RetransmissionTimeout to change it.
+	RetransmissionTimeout = DefaultRetransmissionTimeout
+	// Deprecated: Use DefaultMaxRetransmissions, or Config.MaxRetransmissions to change it.
+	MaxRetransmissions = DefaultMaxRetransmissions
+	// Deprecated: Use DefaultInactivityTimeout, or Config.InactivityTimeout to change it.
+	InactivityTimeout = DefaultInactivityTimeout
+)
+
+// MaxUDPPayloadSize is the largest payload a single UDP datagram can carry
+const MaxUDPPayloadSize = 65507
+
+// Config holds the tunable parameters of a Server, Client or Connection.
+// Zero values are replaced with their defaults.
+type Config struct {
+	MaxPacketSize            int                         // Maximum datagram size on the wire, including the header
+	RetransmissionTimeout    time.Duration               // Initial time to wait for an ack before resending, used until the round-trip time is measured
+	MinRetransmissionTimeout time.Duration               // Lower bound of the adaptive retransmission timeout
+	MaxRetransmissionTimeout time.Duration               // Upper bound of the adaptive retransmission timeout, including backoff
+	MaxRetransmissions       int                         // Attempts before a reliable packet is given up on
+	InactivityTimeout        time.Duration               // Time without receiving anything before a connection is considered dead
+	HeartbeatInterval        time.Duration               // Time without sending anything before a PING is sent
+	AckDelay                 time.Duration               // Time to wait for outgoing traffic to carry acks before sending a standalone ACK
+	HandshakeTimeout         time.Duration               // Time to wait for the server to accept a connection
+	InboundBufferSize        int                         // Packets queued for the application
+	OutboundBufferSize       int                         // Packets queued for the socket
+	Linger                   time.Duration               // Time Close waits for pending reliable packets to be acked before disconnecting
+	Channels                 int                         // Number of logical channels, numbered from 0, each ordered independently
+	MaxFragments             int                         // Most fragments a message may be split into, up to MaxFragmentCount
+	FragmentTimeout          time.Duration               // Time to wait for the remaining fragments of a message before dropping it
+	MaxReassemblyBytes       int                         // Memory a connection may hold in incomplete messages, counting their bookkeeping
+	FragmentUnreliable       bool                        // Whether unreliable messages may be fragmented, losing the message if any fragment is lost
+	Secure                   bool                        // Whether to exchange keys during the handshake and encrypt all packets; must match on both sides
+	TokenSecret              []byte                      // Secret shared with the connect token issuer; when set, servers only accept clients with a valid token
+	PublicAddr               string                      // Address of the server as listed in connect tokens, such as "game.example.com:8080"; required with TokenSecret
+	MaxConnections           int                         // Most clients a server accepts before rejecting with RejectServerFull, 0 for no limit
+	AppID                    uint32                      // Identifies the application; clients are only accepted by servers with the same AppID
+	Checksum                 bool                        // Whether to append a CRC32C to every packet and drop packets without a valid one; must match on both sides
+	ResumeGracePeriod        time.Duration               // Time a timed-out connection is kept so the client can resume it, 0 to close it at once; enables reconnecting on clients
+	ReconnectBackoff         time.Duration               // Time a client waits for its first reconnection attempt to succeed, doubled after each failed one
+	MaxReconnectBackoff      time.Duration               // Upper bound of the reconnection backoff
+	MigrationInterval        time.Duration               // Minimum time between two address changes of a connection
+	NewCongestionController  func() CongestionController // Creates the congestion controller of each connection, defaults to NewReno
+	SendRate                 int                         // Bytes per second a connection may send, 0 for no limit
+	ServerSendRate           int                         // Bytes per second a server may send across all connections, 0 for no limit
+	BatchInterval            time.Duration               // Time small messages are held to be sent together in one datagram, 0 to send each at once
+	PathMTUDiscovery         bool                        // Whether to start from a datagram size any path carries and probe for the largest one up to MaxPacketSize
+	MTUProbeInterval         time.Duration               // How often the discovered path MTU is revalidated
+}
+
+// DefaultConfig returns the default configuration
+func DefaultConfig() Config {
+	return Config{
+		MaxPacketSize:            DefaultMaxPacketSize,
+		RetransmissionTimeout:    DefaultRetransmissionTimeout,
+		MinRetransmissionTimeout: DefaultMinRetransmissionTimeout,
+		MaxRetransmissionTimeout: DefaultMaxRetransmissionTimeout,
+		MaxRetransmissions:       DefaultMaxRetransmissions,
+		InactivityTimeout:        DefaultInactivityTimeout,
+		HeartbeatInterval:        DefaultHeartbeatInterval,
+		AckDelay:                 DefaultAckDelay,
+		HandshakeTimeout:         DefaultHandshakeTimeout,
+		InboundBufferSize:        DefaultBufferSize,
+		OutboundBufferSize:       DefaultBufferSize,
+		Channels:                 DefaultChannels,
+		MaxFragments:             DefaultMaxFragments,
+		FragmentTimeout:          DefaultFragmentTimeout,
+		MaxReassemblyBytes:       DefaultMaxReassemblyBytes,
+		ReconnectBackoff:         DefaultReconnectBackoff,
+		MaxReconnectBackoff:      DefaultMaxReconnectBackoff,
+		MigrationInterval:        DefaultMigrationInterval,
+		NewCongestionController:  newDefaultCongestionController,
+		MTUProbeInterval:         DefaultMTUProbeInterval,
+	}
+}
+
+// withDefaults returns a copy of the config with zero values replaced by defaults
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.MaxPacketSize == 0 {
+		c.MaxPacketSize = d.MaxPacketSize
+	}
+	if c.RetransmissionTimeout == 0 {
+		c.RetransmissionTimeout = d.RetransmissionTimeout
+	}
+	if c.MinRetransmissionTimeout == 0 {
+		c.MinRetransmissionTimeout = d.MinRetransmissionTimeout
+	}
+	if c.MaxRetransmissionTimeout == 0 {
+		c.MaxRetransmissionTimeout = d.MaxRetransmissionTimeout
+	}
+	if c.MaxRetransmissions == 0 {
+		c.MaxRetransmissions = d.MaxRetransmissions
+	}
+	if c.InactivityTimeout == 0 {
+		c.InactivityTimeout = d.InactivityTimeout
+	}
+	if c.HeartbeatInterval == 0 {
+		c.HeartbeatInterval = d.HeartbeatInterval
+	}
+	if c.AckDelay == 0 {
+		c.AckDelay = d.AckDelay
+	}
+	if c.HandshakeTimeout == 0 {
+		c.HandshakeTimeout = d.HandshakeTimeout
+	}
+	if c.InboundBufferSize == 0 {
+		c.InboundBufferSize = d.InboundBufferSize
+	}
+	if c.OutboundBufferSize == 0 {
+		c.OutboundBufferSize = d.OutboundBufferSize
+	}
+	if c.Channels == 0 {
+		c.Channels = d.Channels
+	}
+	if c.MaxFragments == 0 {
+		c.MaxFragments = d.MaxFragments
+	}
+	if c.FragmentTimeout == 0 {
+		c.FragmentTimeout = d.FragmentTimeout
+	}
+	if c.MaxReassemblyBytes == 0 {
+		c.MaxReassemblyBytes = d.MaxReassemblyBytes
+	}
+	if c.ReconnectBackoff == 0 {
+		c.ReconnectBackoff = d.ReconnectBackoff
+	}
+	if c.MaxReconnectBackoff == 0 {
+		c.MaxReconnectBackoff = d.MaxReconnectBackoff
+	}
+	if c.MigrationInterval == 0 {
+		c.MigrationInterval = d.MigrationInterval
+	}
+	if c.MTUProbeInterval == 0 {
+		c.MTUProbeInterval = d.MTUProbeInterval
+	}
+	if c.NewCongestionController == nil {
+		c.NewCongestionController = d.NewCongestionController
+	}
+	return c
+}
+
+// Validate checks the config for values that cannot work.
+// Zero values are valid and mean "use the default".
+func (c Config) Validate() error {
+	if c.MaxPacketSize != 0 && (c.MaxPacketSize <= HeaderSize+FragmentHeaderSize || c.MaxPacketSize > MaxUDPPayloadSize) {
+		return fmt.Errorf("%w: MaxPacketSize must be between %d and %d", ErrInvalidConfig, HeaderSize+FragmentHeaderSize+1, MaxUDPPayloadSize)
+	}
+	if c.RetransmissionTimeout < 0 {
+		return fmt.Errorf("%w: RetransmissionTimeout must not be negative", ErrInvalidConfig)
+	}
+	if c.MinRetransmissionTimeout < 0 {
+		return fmt.Errorf("%w: MinRetransmissionTimeout must not be negative", ErrInvalidConfig)
+	}
+	if c.MaxRetransmissionTimeout < 0 {
+		return fmt.Errorf("%w: MaxRetransmissionTimeout must not be negative", ErrInvalidConfig)
+	}
+	if c.MaxRetransmissions < 0 {
+		return fmt.Errorf("%w: MaxRetransmissions must not be negative", ErrInvalidConfig)
+	}
+	if c.InactivityTimeout < 0 {
+		return fmt.Errorf("%w: InactivityTimeout must not be negative", ErrInvalidConfig)
+	}
+	if c.HeartbeatInterval < 0 {
+		return fmt.Errorf("%w: HeartbeatInterval must not be negative", ErrInvalidConfig)
+	}
+	if c.AckDelay < 0 {
+		return fmt.Errorf("%w: AckDelay must not be negative", ErrInvalidConfig)
+	}
+	if c.HandshakeTimeout < 0 {
+		return fmt.Errorf("%w: HandshakeTimeout must not be negative", ErrInvalidConfig)
+	}
+	if c.InboundBufferSize < 0 {
+		return fmt.Errorf("%w: InboundBufferSize must not be negative", ErrInvalidConfig)
+	}
+	if c.OutboundBufferSize < 0 {
+		return fmt.Errorf("%w: OutboundBufferSize must not be negative", ErrInvalidConfig)
+	}
+	if c.Linger < 0 {
+		return fmt.Errorf("%w: Linger must not be negative", ErrInvalidConfig)
+	}
+	if c.Channels < 0 || c.Channels > 256 {
+		return fmt.Errorf("%w: Channels must be between 1 and 256", ErrInvalidConfig)
+	}
+	if c.MaxFragments < 0 || c.MaxFragments > MaxFragmentCount {
+		return fmt.Errorf("%w: MaxFragments must be between 1 and %d", ErrInvalidConfig, MaxFragmentCount)
+	}
+	if c.FragmentTimeout < 0 {
+		return fmt.Errorf("%w: FragmentTimeout must not be negative", ErrInvalidConfig)
+	}
+	if c.MaxReassemblyBytes < 0 {
+		return fmt.Errorf("%w: MaxReassemblyBytes must not be negative", ErrInvalidConfig)
+	}
+	if c.MaxConnections < 0 {
+		return fmt.Errorf("%w: MaxConnections must not be negative", ErrInvalidConfig)
+	}
+	if c.ResumeGracePeriod < 0 {
+		return fmt.Errorf("%w: ResumeGracePeriod must not be negative", ErrInvalidConfig)
+	}
+	if c.ReconnectBackoff < 0 {
+		return fmt.Errorf("%w: ReconnectBackoff must not be negative", ErrInvalidConfig)
+	}
+	if c.MaxReconnectBackoff < 0 {
+		return fmt.Errorf("%w: MaxReconnectBackoff must not be negative", ErrInvalidConfig)
+	}
+	if c.MigrationInterval < 0 {
+		return fmt.Errorf("%w: MigrationInterval must not be negative", ErrInvalidConfig)
+	}
+	if c.SendRate < 0 {
+		return fmt.Errorf("%w: SendRate must not be negative", ErrInvalidConfig)
+	}
+	if c.ServerSendRate < 0 {
+		return fmt.Errorf("%w: ServerSendRate must not be negative", ErrInvalidConfig)
+	}
+	if c.BatchInterval < 0 {
+		return fmt.Errorf("%w: BatchInterval must not be negative", ErrInvalidConfig)
+	}
+	if c.MTUProbeInterval < 0 {
+		return fmt.Errorf("%w: MTUProbeInterval must not be negative", ErrInvalidConfig)
+	}
+	if len(c.TokenSecret) != 0 && len(c.TokenSecret) != token.SecretSize {
+		return fmt.Errorf("%w: TokenSecret must be %d bytes", ErrInvalidConfig, token.SecretSize)
+	}
+	if len(c.TokenSecret) != 0 && c.PublicAddr == "" {
+		// Tokens list the address clients dial, which the address a server listens on rarely is
+		return fmt.Errorf("%w: PublicAddr must be set with TokenSecret", ErrInvalidConfig)
+	}
+
+	d := c.withDefaults()
+	if d.MinRetransmissionTimeout > d.MaxRetransmissionTimeout {
+		return fmt.Errorf("%w: MinRetransmissionTimeout must not exceed MaxRetransmissionTimeout", ErrInvalidConfig)
+	}
+	if d.ReconnectBackoff > d.MaxReconnectBackoff {
+		return fmt.Errorf("%w: ReconnectBackoff must not exceed MaxReconnectBackoff", ErrInvalidConfig)
+	}
+	if d.InactivityTimeout <= d.RetransmissionTimeout {
+		return fmt.Errorf("%w: InactivityTimeout must be greater than RetransmissionTimeout", ErrInvalidConfig)
+	}
+	if d.InactivityTimeout <= d.HeartbeatInterval {
+		return fmt.Errorf("%w: InactivityTimeout must be greater than HeartbeatInterval", ErrInvalidConfig)
+	}
+	if d.Checksum && d.MaxPacketSize <= d.overhead()+FragmentHeaderSize {
+		return fmt.Errorf("%w: MaxPacketSize must be greater than %d with checksums", ErrInvalidConfig, d.overhead()+FragmentHeaderSize)
+	}
+	if d.secure() && d.MaxPacketSize <= d.overhead()+SecureOverhead+FragmentHeaderSize {
+		return fmt.Errorf("%w: MaxPacketSize must be greater than %d in secure mode", ErrInvalidConfig, d.overhead()+SecureOverhead+FragmentHeaderSize)
+	}
+	return nil
+}
+
+// overhead returns the bytes every packet adds to its payload, not counting secure mode
+func (c Config) overhead() int {
+	if c.Checksum {
+		return HeaderSize + ChecksumSize
+	}
+	return HeaderSize
+}
+
+// marshal serializes a handshake packet, appending a checksum if enabled
+func (c Config) marshal(packet *Packet) []byte {
+	buf := packet.Marshal()
+	if c.Checksum {
+		buf = appendChecksum(buf, checksumSeed(c.AppID))
+	}
+	return buf
+}
+
+// unmarshal deserializes a packet, verifying its checksum as sent by the same application
+func (c Config) unmarshal(packet *Packet, data []byte) error {
+	return packet.unmarshal(data, checksumSeed(c.AppID))
+}
+
+// secure reports whether connections exchange keys and encrypt their packets,
+// which connect tokens always require
+func (c Config) secure() bool {
+	return c.Secure || len(c.TokenSecret) > 0
+}
@@ -0,0 +1,142 @@
+package rudp
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cbodonnell/rudp/token"
+)
+
+func TestValidate(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr error
+	}{
+		{"zero", Config{}, nil},
+		{"default", DefaultConfig(), nil},
+		{"MaxPacketSize too small", Config{MaxPacketSize: HeaderSize + FragmentHeaderSize}, ErrInvalidConfig},
+		{"MaxPacketSize too large", Config{MaxPacketSize: MaxUDPPayloadSize + 1}, ErrInvalidConfig},
+		{"negative MaxPacketSize", Config{MaxPacketSize: -1}, ErrInvalidConfig},
+		{"negative RetransmissionTimeout", Config{RetransmissionTimeout: -ms}, ErrInvalidConfig},
+		{"negative MinRetransmissionTimeout", Config{MinRetransmissionTimeout: -ms}, ErrInvalidConfig},
+		{"negative MaxRetransmissionTimeout", Config{MaxRetransmissionTimeout: -ms}, ErrInvalidConfig},
+		{"negative MaxRetransmissions", Config{MaxRetransmissions: -1}, ErrInvalidConfig},
+		{"negative InactivityTimeout", Config{InactivityTimeout: -ms}, ErrInvalidConfig},
+		{"negative HeartbeatInterval", Config{HeartbeatInterval: -ms}, ErrInvalidConfig},
+		{"negative AckDelay", Config{AckDelay: -ms}, ErrInvalidConfig},
+		{"negative HandshakeTimeout", Config{HandshakeTimeout: -ms}, ErrInvalidConfig},
+		{"negative InboundBufferSize", Config{InboundBufferSize: -1}, ErrInvalidConfig},
+		{"negative OutboundBufferSize", Config{OutboundBufferSize: -1}, ErrInvalidConfig},
+		{"negative Linger", Config{Linger: -ms}, ErrInvalidConfig},
+		{"negative Channels", Config{Channels: -1}, ErrInvalidConfig},
+		{"too many Channels", Config{Channels: 257}, ErrInvalidConfig},
+		{"negative MaxFragments", Config{MaxFragments: -1}, ErrInvalidConfig},
+		{"too many MaxFragments", Config{MaxFragments: MaxFragmentCount + 1}, ErrInvalidConfig},
+		{"negative FragmentTimeout", Config{FragmentTimeout: -ms}, ErrInvalidConfig},
+		{"negative MaxReassemblyBytes", Config{MaxReassemblyBytes: -1}, ErrInvalidConfig},
+		{"negative MaxConnections", Config{MaxConnections: -1}, ErrInvalidConfig},
+		{"negative ResumeGracePeriod", Config{ResumeGracePeriod: -ms}, ErrInvalidConfig},
+		{"negative ReconnectBackoff", Config{ReconnectBackoff: -ms}, ErrInvalidConfig},
+		{"negative MaxReconnectBackoff", Config{MaxReconnectBackoff: -ms}, ErrInvalidConfig},
+		{"negative MigrationInterval", Config{MigrationInterval: -ms}, ErrInvalidConfig},
+		{"negative SendRate", Config{SendRate: -1}, ErrInvalidConfig},
+		{"negative ServerSendRate", Config{ServerSendRate: -1}, ErrInvalidConfig},
+		{"negative BatchInterval", Config{BatchInterval: -ms}, ErrInvalidConfig},
+		{"negative MTUProbeInterval", Config{MTUProbeInterval: -ms}, ErrInvalidConfig},
+		{"InactivityTimeout within RetransmissionTimeout", Config{InactivityTimeout: 50 * ms}, ErrInvalidConfig},
+		{"InactivityTimeout within HeartbeatInterval", Config{HeartbeatInterval: 10 * time.Second}, ErrInvalidConfig},
+		{"MinRetransmissionTimeout above MaxRetransmissionTimeout", Config{MinRetransmissionTimeout: 3 * time.Second}, ErrInvalidConfig},
+		{"ReconnectBackoff above MaxReconnectBackoff", Config{ReconnectBackoff: 5 * time.Second}, ErrInvalidConfig},
+		{"MaxPacketSize too small for checksums", Config{MaxPacketSize: HeaderSize + FragmentHeaderSize + 1, Checksum: true}, ErrInvalidConfig},
+		{"MaxPacketSize too small for secure mode", Config{MaxPacketSize: HeaderSize + FragmentHeaderSize + 1, Secure: true}, ErrInvalidConfig},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithDefaults(t *testing.T) {
+	// Functions cannot be compared, so the congestion controller is checked on its own
+	comparable := func(c Config) Config {
+		c.NewCongestionController = nil
+		return c
+	}
+
+	got := Config{}.withDefaults()
+	if got.NewCongestionController == nil {
+		t.Error("withDefaults() left NewCongestionController unset")
+	}
+	if want := DefaultConfig(); !reflect.DeepEqual(comparable(got), comparable(want)) {
+		t.Errorf("Config{}.withDefaults() = %+v, want %+v", got, want)
+	}
+
+	// Values that are set are kept
+	set := Config{
+		MaxPacketSize:            500,
+		RetransmissionTimeout:    time.Second,
+		MinRetransmissionTimeout: time.Millisecond,
+		MaxRetransmissionTimeout: time.Minute,
+		MaxRetransmissions:       9,
+		InactivityTimeout:        time.Hour,
+		HeartbeatInterval:        2 * time.Second,
+		AckDelay:                 time.Millisecond,
+		HandshakeTimeout:         time.Minute,
+		InboundBufferSize:        1,
+		OutboundBufferSize:       2,
+		Channels:                 3,
+		MaxFragments:             4,
+		FragmentTimeout:          time.Minute,
+		MaxReassemblyBytes:       5,
+		ReconnectBackoff:         time.Second,
+		MaxReconnectBackoff:      time.Minute,
+		MigrationInterval:        time.Minute,
+		MTUProbeInterval:         time.Hour,
+	}
+	if got := set.withDefaults(); !reflect.DeepEqual(comparable(got), set) {
+		t.Errorf("withDefaults() = %+v, want %+v", got, set)
+	}
+}
+
+func TestNewWithInvalidConfig(t *testing.T) {
+	config := Config{MaxRetransmissions: -1}
+	if _, err := NewServerWithConfig(config); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewServerWithConfig() error = %v, want %v", err, ErrInvalidConfig)
+	}
+	if _, err := NewClientWithConfig(config); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewClientWithConfig() error = %v, want %v", err, ErrInvalidConfig)
+	}
+	if _, err := NewConnectionWithConfig(nil, nil, 1, config); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewConnectionWithConfig() error = %v, want %v", err, ErrInvalidConfig)
+	}
+}
+
+func TestValidateTokenSecret(t *testing.T) {
+	secret := make([]byte, token.SecretSize)
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr error
+	}{
+		{"no tokens", Config{}, nil},
+		{"tokens with public address", Config{TokenSecret: secret, PublicAddr: "game.example.com:8080"}, nil},
+		{"tokens without public address", Config{TokenSecret: secret}, ErrInvalidConfig},
+		{"short secret", Config{TokenSecret: secret[:16], PublicAddr: "game.example.com:8080"}, ErrInvalidConfig},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package rudp
+
+import (
+	"time"
+)
+
+// CongestionController decides how many reliable packets a connection may have in
+// flight, sent but not yet acknowledged. Reliable messages beyond the window wait in
+// a backlog until acks make room, so a congested link is not flooded with new packets
+// on top of retransmissions. Unreliable packets are not limited. Calls are made with
+// the connection's lock held, so implementations need no locking of their own.
+type CongestionController interface {
+	Window() int             // Reliable packets that may be in flight
+	OnAck(sentAt time.Time)  // A packet last sent at sentAt was acknowledged
+	OnLoss(sentAt time.Time) // A packet last sent at sentAt timed out and is being retransmitted
+}
+
+// Window bounds of Reno, in packets
+const (
+	renoInitialWindow = 10
+	renoMinWindow     = 2
+	renoMaxWindow     = 1024
+)
+
+// Reno is a loss-based AIMD congestion controller in the style of TCP NewReno. The
+// window starts in slow start, growing by one packet per ack and so doubling every
+// round trip, until the first loss. After that it grows by about one packet per round
+// trip. A loss halves the window; losses of packets sent before it was halved belong
+// to the same congestion event and do not halve it again.
+type Reno struct {
+	window    float64
+	threshold float64   // Slow start ends once the window reaches it
+	recovery  time.Time // When the window was last halved
+}
+
+// NewReno creates a Reno congestion controller
+func NewReno() *Reno {
+	return &Reno{
+		window:    renoInitialWindow,
+		threshold: renoMaxWindow,
+	}
+}
+
+// Window returns the number of reliable packets that may be in flight
+func (r *Reno) Window() int {
+	return int(r.window)
+}
+
+// OnAck grows the window, unless the packet was sent before the last loss
+func (r *Reno) OnAck(sentAt time.Time) {
+	if !sentAt.After(r.recovery) {
+		return
+	}
+	if r.window < r.threshold {
+		r.window++
+	} else {
+		r.window += 1 / r.window
+	}
+	r.window = min(r.window, renoMaxWindow)
+}
+
+// OnLoss halves the window once per congestion event
+func (r *Reno) OnLoss(sentAt time.Time) {
+	if !sentAt.After(r.recovery) {
+		return
+	}
+	r.window = max(r.window/2, renoMinWindow)
+	r.threshold = r.window
+	r.recovery = time.Now()
+}
+
+// CongestionWindow returns how many reliable packets may currently be in flight
+func (c *Connection) CongestionWindow() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.congestion.Window()
+}
+
+// newDefaultCongestionController creates the congestion controller used when
+// Config.NewCongestionController is not set
+func newDefaultCongestionController() CongestionController {
+	return NewReno()
+}
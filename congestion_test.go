@@ -0,0 +1,106 @@
+package rudp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReno(t *testing.T) {
+	start := time.Now()
+	type event struct {
+		loss   bool
+		sentAt time.Duration // Since start, compared against when the window was last halved
+		count  int
+	}
+
+	tests := []struct {
+		name   string
+		events []event
+		want   float64
+	}{
+		{"initial", nil, renoInitialWindow},
+		{"slow start", []event{{sentAt: time.Second, count: 5}}, renoInitialWindow + 5},
+		{"loss halves", []event{{loss: true, sentAt: time.Second, count: 1}}, renoInitialWindow / 2},
+		{"losses of one event halve once", []event{{loss: true, sentAt: time.Second, count: 3}}, renoInitialWindow / 2},
+		{"floor", []event{
+			{loss: true, sentAt: time.Second, count: 1},
+			{loss: true, sentAt: time.Hour, count: 1},
+			{loss: true, sentAt: 2 * time.Hour, count: 1},
+		}, renoMinWindow},
+		{"acks of packets sent before the loss do not grow", []event{
+			{loss: true, sentAt: time.Second, count: 1},
+			{sentAt: time.Second, count: 5},
+		}, renoInitialWindow / 2},
+		{"congestion avoidance", []event{
+			{loss: true, sentAt: time.Second, count: 1},
+			{sentAt: time.Hour, count: 6},
+		}, 6}, // Past the threshold of 5, a window's worth of acks adds about one packet
+		{"ceiling", []event{{sentAt: time.Second, count: 2 * renoMaxWindow}}, renoMaxWindow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReno()
+			r.recovery = start
+			for _, e := range tt.events {
+				for i := 0; i < e.count; i++ {
+					if e.loss {
+						r.OnLoss(start.Add(e.sentAt))
+						r.recovery = start.Add(e.sentAt) // Halved when the packet was sent, for a deterministic clock
+					} else {
+						r.OnAck(start.Add(e.sentAt))
+					}
+				}
+			}
+			if got := r.Window(); got != int(tt.want) {
+				t.Errorf("Window() = %d (%.2f), want %d", got, r.window, int(tt.want))
+			}
+		})
+	}
+}
+
+// fixedWindow is a congestion controller with a window that does not change
+type fixedWindow int
+
+func (w fixedWindow) Window() int    { return int(w) }
+func (fixedWindow) OnAck(time.Time)  {}
+func (fixedWindow) OnLoss(time.Time) {}
+
+func TestAdmitBacklog(t *testing.T) {
+	config := DefaultConfig()
+	config.NewCongestionController = func() CongestionController { return fixedWindow(3) }
+	c := newTestConnection(config)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := 0; i < 5; i++ {
+		c.queue(&Packet{Type: DATA, Mode: Reliable, Data: []byte{byte(i)}})
+	}
+	c.queue(&Packet{Type: DATA, Mode: Unreliable})
+	if len(c.pendingAcks) != 3 || len(c.backlog) != 2 {
+		t.Fatalf("%d in flight and %d backlogged, want the window of 3 in flight and 2 backlogged", len(c.pendingAcks), len(c.backlog))
+	}
+	if len(c.outbound) != 4 {
+		t.Errorf("%d packets queued for the socket, want 3 reliable and the unreliable one", len(c.outbound))
+	}
+
+	// Without acks nothing more is admitted
+	c.admitBacklog()
+	if len(c.pendingAcks) != 3 {
+		t.Errorf("%d in flight after admitting without acks, want 3", len(c.pendingAcks))
+	}
+
+	// Each ack makes room for one backlogged packet, in the order they were sent
+	c.processAcknowledgments(0, 0)
+	if len(c.pendingAcks) != 3 || len(c.backlog) != 1 {
+		t.Errorf("%d in flight and %d backlogged after one ack, want 3 and 1", len(c.pendingAcks), len(c.backlog))
+	}
+	if packet := c.pendingAcks[4]; packet == nil || packet.Data[0] != 3 {
+		t.Errorf("admitted %v as sequence 4, want the first backlogged packet", packet)
+	}
+	c.processAcknowledgments(4, 0b111)
+	if len(c.pendingAcks) != 1 || len(c.backlog) != 0 {
+		t.Errorf("%d in flight and %d backlogged after acking all, want 1 and 0", len(c.pendingAcks), len(c.backlog))
+	}
+}
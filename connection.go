@@ -1,15 +1,13 @@
 package rudp
 
 import (
+	"container/list"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const (
-	InactivityTimeout = 5 * time.Second // Timeout for connection inactivity
-)
-
 // Connection represents a reliable UDP connection to a peer
 type Connection struct {
 	mu   sync.RWMutex
@@ -18,21 +16,81 @@ type Connection struct {
 
 	// Identity
 	clientID uint32
+	config   Config
+
+	// Security
+	session          *session // Keys of a secure connection, nil when packets are sent in the clear
+	handshake        []byte   // CONNECT_RESPONSE payload that created the connection, to recognize resends
+	handshakeReply   []byte   // CONNECT_ACK payload, resent when the client did not receive it
+	handshakePayload []byte   // Application data the peer sent with its handshake
+	userID           uint64   // User the connect token was issued to
+	userData         []byte   // Application data from the connect token
+	resumeKey        []byte   // Key proving the right to resume the connection, nil if it cannot be resumed
+	pathKey          []byte   // Key proving the right to move the connection to a new address
 
 	// Sequence tracking
-	localSequence  uint16
-	remoteSequence uint16
-	ackBits        uint32
+	localSequence    uint16
+	remoteSequence   uint16
+	remoteReceivedAt time.Time // When remoteSequence was received, for the delay reported by standalone ACKs
+	ackBits          uint32
+	receivedAny      bool             // Whether remoteSequence holds a received sequence yet
+	received         *receivedHistory // Recently received sequences, for duplicates and late acks
+	ackPending       bool             // Whether received reliable packets await an ack
+	ackPendingFrom   uint16           // Newest received sequence when ackPending was set
+	ackTimer         *time.Timer      // Fires a standalone ACK after AckDelay
 
 	// Reliability
-	pendingAcks   map[uint16]*Packet
-	recvBuffer    map[uint16]*Packet
-	orderedBuffer map[uint16]*Packet
+	rtt         rttEstimator
+	pendingAcks map[uint16]*Packet
+	recvBuffer  map[uint16]*Packet
+	streams     map[streamKey]*orderedStream // Ordering state per channel and ordered delivery mode
+
+	// Congestion control
+	congestion CongestionController // Decides how many reliable packets may be in flight
+	backlog    []*Packet            // Reliable packets waiting for room in the congestion window
+
+	// Batching
+	batches    [2]*Packet  // BATCH packets being filled with unreliable and reliable messages
+	batchTimer *time.Timer // Sends the batches after BatchInterval
+
+	// Path MTU discovery
+	mtu       atomic.Int32 // Largest datagram sent, read without the lock
+	mtuSearch mtuSearch
+
+	// Pacing
+	limiter       *tokenBucket // Enforces SendRate, nil if unlimited
+	serverLimiter *tokenBucket // Enforces the server's ServerSendRate, nil if unlimited
+	queuedBytes   atomic.Int64 // Bytes in the outbound buffer
+
+	// Fragmentation
+	nextFragmentID  uint16
+	reassemblies    map[uint16]*reassembly // Incomplete messages keyed by fragment ID
+	reassemblyOrder *list.List             // Fragment IDs of incomplete messages in the order they started
+	reassemblyBytes int                    // Memory held in incomplete messages
+
+	// Statistics
+	counters connectionCounters
 
 	// State
 	lastReceived time.Time
 	lastSent     time.Time
+	closing      bool
 	closed       bool
+	suspended    bool      // Timed out, but can still be resumed within ResumeGracePeriod
+	suspendedAt  time.Time // When the connection was suspended
+
+	// Migration
+	challenge     *pathChallenge // Outstanding challenge to an address the connection may move to
+	lastMigration time.Time      // When the connection last moved to a new address
+
+	// Disconnect
+	disconnectReason  DisconnectReason
+	disconnectPayload []byte
+
+	// Events
+	onDeliveryFailed func(*Packet) // Called when a reliable packet exhausts its retransmissions
+	onSuspend        func()        // Called when the connection is suspended
+	onResume         func()        // Called when a suspended connection is resumed
 
 	// Channels
 	inbound  chan *Packet
@@ -40,63 +98,186 @@ type Connection struct {
 	done     chan struct{}
 }
 
-// NewConnection creates a new connection to the specified address
+// NewConnection creates a new connection to the specified address using the default configuration
 func NewConnection(conn *net.UDPConn, addr *net.UDPAddr, clientID uint32) *Connection {
+	c := newConnection(conn, addr, clientID, DefaultConfig())
+	c.start()
+	return c
+}
+
+// NewConnectionWithConfig creates a new connection to the specified address using the given configuration
+func NewConnectionWithConfig(conn *net.UDPConn, addr *net.UDPAddr, clientID uint32, config Config) (*Connection, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	c := newConnection(conn, addr, clientID, config)
+	c.start()
+	return c, nil
+}
+
+// newConnection creates a connection from an already validated config.
+// Its goroutines are not running until start is called, so hooks can be set without racing them.
+func newConnection(conn *net.UDPConn, addr *net.UDPAddr, clientID uint32, config Config) *Connection {
+	config = config.withDefaults()
 	c := &Connection{
-		addr:          addr,
-		conn:          conn,
-		clientID:      clientID,
-		pendingAcks:   make(map[uint16]*Packet),
-		recvBuffer:    make(map[uint16]*Packet),
-		orderedBuffer: make(map[uint16]*Packet),
-		lastReceived:  time.Now(),
-		inbound:       make(chan *Packet, 256),
-		outbound:      make(chan *Packet, 256),
-		done:          make(chan struct{}),
+		addr:            addr,
+		conn:            conn,
+		clientID:        clientID,
+		config:          config,
+		remoteSequence:  ^uint16(0), // Acks sent before anything is received must not acknowledge the peer's first sequence
+		received:        newReceivedHistory(),
+		rtt:             newRTTEstimator(config.RetransmissionTimeout, config.MinRetransmissionTimeout, config.MaxRetransmissionTimeout),
+		pendingAcks:     make(map[uint16]*Packet),
+		recvBuffer:      make(map[uint16]*Packet),
+		streams:         make(map[streamKey]*orderedStream),
+		congestion:      config.NewCongestionController(),
+		limiter:         newTokenBucket(config.SendRate, config.MaxPacketSize),
+		reassemblies:    make(map[uint16]*reassembly),
+		reassemblyOrder: list.New(),
+		lastReceived:    time.Now(),
+		lastSent:        time.Now(),
+		inbound:         make(chan *Packet, config.InboundBufferSize),
+		outbound:        make(chan *Packet, config.OutboundBufferSize),
+		done:            make(chan struct{}),
 	}
+	c.startMTUDiscovery()
+	return c
+}
+
+// start launches the goroutines that send, retransmit and keep the connection alive.
+// The inactivity timeout counts from here rather than from before the handshake.
+func (c *Connection) start() {
+	c.mu.Lock()
+	c.lastReceived = time.Now()
+	c.mu.Unlock()
 
 	go c.processOutbound()
 	go c.processRetransmissions()
-
-	return c
+	go c.processHeartbeats()
 }
 
-// Send queues a packet for transmission
+// Send queues a packet for transmission on the default channel 0
 func (c *Connection) Send(data []byte, mode DeliveryMode) error {
+	return c.send(0, data, mode, nil)
+}
+
+// SendOnChannel queues a packet for transmission on the given channel.
+// Each channel orders its packets independently of the others.
+func (c *Connection) SendOnChannel(channel uint8, data []byte, mode DeliveryMode) error {
+	return c.send(channel, data, mode, nil)
+}
+
+// send queues a packet for transmission, attaching the receipt if given.
+// Data too large for one packet is split into fragments.
+func (c *Connection) send(channel uint8, data []byte, mode DeliveryMode, receipt *Receipt) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.closed {
+	if c.closed || c.closing {
 		return ErrConnectionClosed
 	}
 
-	if len(data) > MaxPacketSize-HeaderSize {
-		return ErrPacketTooLarge
+	if int(channel) >= c.config.Channels {
+		return ErrInvalidChannel
 	}
 
 	packet := &Packet{
 		Type:      DATA,
 		ClientID:  c.clientID,
-		Sequence:  c.localSequence,
-		Ack:       c.remoteSequence,
-		AckBits:   c.ackBits,
 		Mode:      mode,
+		Channel:   channel,
 		Data:      data,
 		Timestamp: time.Now().UnixNano(),
+		receipt:   receipt,
+	}
+
+	if c.batchable(packet) {
+		return c.addToBatch(packet)
+	}
+
+	packets := []*Packet{packet}
+	if len(data) > c.maxPayload() {
+		fragments, err := c.fragment(packet)
+		if err != nil {
+			return err
+		}
+		packets = fragments
 	}
 
+	// Queue all fragments or none of them. Reliable packets the congestion window
+	// has no room for wait in the backlog, the others go straight to the socket.
+	if packet.IsReliable() {
+		if len(packets) > c.config.OutboundBufferSize-len(c.backlog) {
+			return ErrBufferFull
+		}
+	} else if len(packets) > cap(c.outbound)-len(c.outbound) {
+		return ErrBufferFull
+	}
+
+	if receipt != nil {
+		receipt.remaining = len(packets)
+	}
+
+	c.assignOrderSequence(packet)
+	for _, p := range packets {
+		p.OrderSequence = packet.OrderSequence
+		c.queue(p)
+	}
+	return nil
+}
+
+// assignOrderSequence gives an ordered message the next position in its stream.
+// Must be called with the lock held.
+func (c *Connection) assignOrderSequence(packet *Packet) {
+	if packet.IsOrdered() {
+		stream := c.stream(packet.Channel, packet.Mode)
+		packet.OrderSequence = stream.nextSend
+		stream.nextSend++
+	}
+}
+
+// queue sends a packet, or backlogs it if it is reliable and the congestion window has
+// no room for it. Must be called with the lock held.
+func (c *Connection) queue(packet *Packet) {
+	if packet.IsReliable() && (len(c.backlog) > 0 || !c.hasRoom()) {
+		c.backlog = append(c.backlog, packet)
+		return
+	}
+	c.admit(packet)
+}
+
+// admit assigns a packet its sequence and the current acks and queues it for the socket.
+// Must be called with the lock held.
+func (c *Connection) admit(packet *Packet) {
+	packet.Sequence = c.localSequence
+	packet.Ack = c.remoteSequence
+	packet.AckBits = c.ackBits
 	c.localSequence++
 
 	if packet.IsReliable() {
 		c.pendingAcks[packet.Sequence] = packet
 	}
+	c.ackPending = false
 
-	select {
-	case c.outbound <- packet:
-		return nil
-	default:
-		return ErrBufferFull
+	// If the buffer is full, reliable packets are sent by the next retransmission
+	c.enqueue(packet)
+}
+
+// hasRoom reports whether the congestion window and the outbound buffer have room for
+// another reliable packet. Must be called with the lock held.
+func (c *Connection) hasRoom() bool {
+	return len(c.pendingAcks) < max(c.congestion.Window(), 1) && len(c.outbound) < cap(c.outbound)
+}
+
+// admitBacklog sends backlogged packets as the congestion window opens.
+// Must be called with the lock held.
+func (c *Connection) admitBacklog() {
+	n := 0
+	for n < len(c.backlog) && c.hasRoom() {
+		c.admit(c.backlog[n])
+		n++
 	}
+	c.backlog = append(c.backlog[:0], c.backlog[n:]...)
 }
 
 // Receive returns the next available packet
@@ -109,29 +290,29 @@ func (c *Connection) Receive() (*Packet, error) {
 	}
 }
 
-// Close closes the connection
+// Close notifies the peer and closes the connection
 func (c *Connection) Close() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if c.closed {
-		return nil
-	}
-
-	c.closed = true
-	close(c.done)
-	return nil
+	return c.CloseWithReason(DisconnectRequested, nil)
 }
 
 // IsConnected returns true if the connection is active
 func (c *Connection) IsConnected() bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	return !c.closed && time.Since(c.lastReceived) < InactivityTimeout
+	return !c.closed && !c.suspended && time.Since(c.lastReceived) < c.config.InactivityTimeout
+}
+
+// IsSuspended returns true if the connection timed out but may still be resumed by the client
+func (c *Connection) IsSuspended() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.suspended
 }
 
 // RemoteAddr returns the remote address of the connection
 func (c *Connection) RemoteAddr() *net.UDPAddr {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.addr
 }
 
@@ -140,6 +321,55 @@ func (c *Connection) ClientID() uint32 {
 	return c.clientID
 }
 
+// Config returns the configuration of the connection
+func (c *Connection) Config() Config {
+	return c.config
+}
+
+// IsSecure returns true if the connection's packets are encrypted and authenticated
+func (c *Connection) IsSecure() bool {
+	return c.session != nil
+}
+
+// HandshakePayload returns the application data the peer sent with its handshake, if any:
+// the client's payload on the server, and the server's reply on the client
+func (c *Connection) HandshakePayload() []byte {
+	return c.handshakePayload
+}
+
+// UserID returns the user the client's connect token was issued to, or 0 if it connected without one
+func (c *Connection) UserID() uint64 {
+	return c.userID
+}
+
+// UserData returns the application data of the client's connect token, if any
+func (c *Connection) UserData() []byte {
+	return c.userData
+}
+
+// maxPayload returns the largest payload that fits in one packet
+func (c *Connection) maxPayload() int {
+	if c.session != nil {
+		return c.MTU() - c.config.overhead() - SecureOverhead
+	}
+	return c.MTU() - c.config.overhead()
+}
+
+// encode serializes a packet for the wire, sealing it on secure connections and
+// appending a checksum if enabled
+func (c *Connection) encode(packet *Packet) []byte {
+	var buf []byte
+	if c.session != nil {
+		buf = c.session.seal(packet)
+	} else {
+		buf = packet.Marshal()
+	}
+	if c.config.Checksum {
+		buf = appendChecksum(buf, checksumSeed(c.config.AppID))
+	}
+	return buf
+}
+
 // UpdateAddr updates the remote address (for handling reconnections)
 func (c *Connection) UpdateAddr(addr *net.UDPAddr) {
 	c.mu.Lock()
@@ -0,0 +1,194 @@
+package rudp
+
+import (
+	"slices"
+	"sync"
+)
+
+// DeliveryStatus is the outcome of sending a reliable message
+type DeliveryStatus byte
+
+const (
+	DeliveryPending   DeliveryStatus = iota // Waiting for an ack
+	DeliveryDelivered                       // The peer acknowledged the message, though it may not have reached OnMessage yet
+	DeliveryFailed                          // Retransmissions were exhausted or the connection closed
+)
+
+// String returns a readable name for the status
+func (s DeliveryStatus) String() string {
+	switch s {
+	case DeliveryPending:
+		return "pending"
+	case DeliveryDelivered:
+		return "delivered"
+	case DeliveryFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// Receipt tracks the delivery of a reliable message
+type Receipt struct {
+	mu        sync.Mutex
+	status    DeliveryStatus
+	remaining int // Packets of the message still awaiting an ack
+	done      chan struct{}
+}
+
+// newReceipt creates a pending receipt for a message sent as a single packet
+func newReceipt() *Receipt {
+	return &Receipt{remaining: 1, done: make(chan struct{})}
+}
+
+// Status returns the current delivery status
+func (r *Receipt) Status() DeliveryStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// Done returns a channel that is closed once the message is delivered or has failed
+func (r *Receipt) Done() <-chan struct{} {
+	return r.done
+}
+
+// Wait blocks until the message is delivered or has failed and returns the outcome
+func (r *Receipt) Wait() DeliveryStatus {
+	<-r.done
+	return r.Status()
+}
+
+// resolve records the outcome of one packet of the message. The message is
+// delivered once all of its packets are acked and fails as soon as any of them fails.
+func (r *Receipt) resolve(status DeliveryStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.status != DeliveryPending {
+		return
+	}
+	if status == DeliveryDelivered {
+		r.remaining--
+		if r.remaining > 0 {
+			return
+		}
+	}
+	r.status = status
+	close(r.done)
+}
+
+// SendWithReceipt queues a reliable packet for transmission on the default channel 0 and
+// returns a receipt that resolves once the peer acknowledges it or retransmissions are exhausted.
+// Delivered means the peer's transport received the message, not that it was handed to
+// OnMessage: a ReliableOrdered message is acked when it is buffered, and is only handed on
+// once the messages before it in its stream arrive or are skipped.
+func (c *Connection) SendWithReceipt(data []byte, mode DeliveryMode) (*Receipt, error) {
+	return c.SendOnChannelWithReceipt(0, data, mode)
+}
+
+// SendOnChannelWithReceipt is SendWithReceipt on the given channel
+func (c *Connection) SendOnChannelWithReceipt(channel uint8, data []byte, mode DeliveryMode) (*Receipt, error) {
+	if !(&Packet{Mode: mode}).IsReliable() {
+		return nil, ErrNotReliable
+	}
+
+	receipt := newReceipt()
+	if err := c.send(channel, data, mode, receipt); err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// resolve resolves the receipt of a packet, or those of the messages of a batch
+func (p *Packet) resolve(status DeliveryStatus) {
+	if p.receipt != nil {
+		p.receipt.resolve(status)
+	}
+	for _, message := range p.batch {
+		if message.receipt != nil {
+			message.receipt.resolve(status)
+		}
+	}
+}
+
+// failPacket gives up on a pending packet and returns the messages to report as failed.
+// The remaining fragments of a fragmented message are given up on with it, since the
+// message can no longer be completed. The peer is told to skip ReliableOrdered messages,
+// and an ORDER_SKIP that is given up on is sent again rather than reported.
+// Must be called with the lock held.
+func (c *Connection) failPacket(packet *Packet) []*Packet {
+	if c.pendingAcks[packet.Sequence] == packet {
+		delete(c.pendingAcks, packet.Sequence)
+	}
+	packet.resolve(DeliveryFailed)
+
+	messages := []*Packet{packet}
+	switch {
+	case packet.Type == ORDER_SKIP:
+		c.queueOrderSkip(packet)
+		return nil
+	case packet.batch != nil:
+		messages = packet.batch
+	case packet.message != nil:
+		for seq, p := range c.pendingAcks {
+			if p.message == packet.message {
+				delete(c.pendingAcks, seq)
+			}
+		}
+		c.backlog = slices.DeleteFunc(c.backlog, func(p *Packet) bool {
+			return p.message == packet.message
+		})
+		messages = []*Packet{packet.message}
+	}
+
+	for _, message := range messages {
+		if message.Mode == ReliableOrdered {
+			c.queueOrderSkip(message)
+		}
+	}
+	return messages
+}
+
+// notifyDeliveryFailed reports packets that were given up on.
+// Must be called without the lock held since the hook may send.
+func (c *Connection) notifyDeliveryFailed(failed []*Packet) {
+	c.mu.RLock()
+	onDeliveryFailed := c.onDeliveryFailed
+	c.mu.RUnlock()
+
+	if onDeliveryFailed == nil {
+		return
+	}
+	for _, packet := range failed {
+		onDeliveryFailed(packet)
+	}
+}
+
+// SetOnDeliveryFailed sets the function called with each reliable message whose
+// retransmissions were exhausted. It may be called at any time, including while the
+// connection is running; nil removes the hook.
+func (c *Connection) SetOnDeliveryFailed(fn func(*Packet)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onDeliveryFailed = fn
+}
+
+// failPending resolves the receipts of all unacknowledged packets as failed
+// when the connection closes. Must be called with the lock held.
+func (c *Connection) failPending() {
+	for seq, packet := range c.pendingAcks {
+		packet.resolve(DeliveryFailed)
+		delete(c.pendingAcks, seq)
+	}
+	for _, packet := range c.backlog {
+		packet.resolve(DeliveryFailed)
+	}
+	c.backlog = nil
+	for i, batch := range c.batches {
+		if batch != nil {
+			batch.resolve(DeliveryFailed)
+			c.batches[i] = nil
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package rudp
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testProxy relays datagrams between a single client and a server, so tests can
+// lose or duplicate them on the way
+type testProxy struct {
+	addr   string       // Address clients connect to
+	copies atomic.Int32 // Times each datagram is delivered, zero to drop them all
+}
+
+// newTestProxy starts a proxy for the server at the given address, delivering
+// every datagram once
+func newTestProxy(t *testing.T, server string) *testProxy {
+	t.Helper()
+	serverAddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	front, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := net.DialUDP("udp", nil, serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		front.Close()
+		back.Close()
+	})
+
+	p := &testProxy{addr: front.LocalAddr().String()}
+	p.copies.Store(1)
+
+	client := make(chan *net.UDPAddr, 1)
+	go func() {
+		buffer := make([]byte, DefaultMaxPacketSize)
+		for first := true; ; first = false {
+			n, addr, err := front.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			if first {
+				client <- addr
+			}
+			for i := int32(0); i < p.copies.Load(); i++ {
+				back.Write(buffer[:n])
+			}
+		}
+	}()
+	go func() {
+		buffer := make([]byte, DefaultMaxPacketSize)
+		addr := <-client
+		for {
+			n, err := back.Read(buffer)
+			if err != nil {
+				return
+			}
+			for i := int32(0); i < p.copies.Load(); i++ {
+				front.WriteToUDP(buffer[:n], addr)
+			}
+		}
+	}()
+	return p
+}
+
+func TestOnDeliveryFailed(t *testing.T) {
+	config := Config{MaxRetransmissions: 2, RetransmissionTimeout: 20 * time.Millisecond}
+
+	tests := []struct {
+		name       string
+		fromServer bool
+	}{
+		{"client", false},
+		{"server", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failed := make(chan *Packet, 4)
+			s, err := NewServerWithConfig(config)
+			if err != nil {
+				t.Fatalf("NewServerWithConfig() error = %v", err)
+			}
+			connected := make(chan *Connection, 1)
+			s.OnConnect = func(conn *Connection) { connected <- conn }
+			s.OnDeliveryFailed = func(_ *Connection, packet *Packet) { failed <- packet }
+			proxy := newTestProxy(t, listenTestServer(t, s))
+
+			c, err := NewClientWithConfig(config)
+			if err != nil {
+				t.Fatalf("NewClientWithConfig() error = %v", err)
+			}
+			c.OnDeliveryFailed = func(packet *Packet) { failed <- packet }
+			if err := c.Connect(proxy.addr); err != nil {
+				t.Fatalf("Connect() error = %v", err)
+			}
+			defer c.Close()
+			conn := <-connected
+
+			// Once the peer is unreachable, every transmission of the message is lost
+			proxy.copies.Store(0)
+			if tt.fromServer {
+				err = conn.Send([]byte("lost"), Reliable)
+			} else {
+				err = c.Send([]byte("lost"), Reliable)
+			}
+			if err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+
+			select {
+			case packet := <-failed:
+				if string(packet.Data) != "lost" {
+					t.Errorf("OnDeliveryFailed got %q, want %q", packet.Data, "lost")
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("OnDeliveryFailed not called")
+			}
+			select {
+			case packet := <-failed:
+				t.Errorf("OnDeliveryFailed called again with %q", packet.Data)
+			case <-time.After(200 * time.Millisecond):
+			}
+		})
+	}
+}
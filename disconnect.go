@@ -0,0 +1,151 @@
+package rudp
+
+import (
+	"fmt"
+	"time"
+)
+
+// DisconnectReason describes why a connection was closed
+type DisconnectReason byte
+
+const (
+	DisconnectNone           DisconnectReason = iota // Connection is still open
+	DisconnectRequested                              // Peer closed the connection
+	DisconnectTimeout                                // Nothing received within InactivityTimeout
+	DisconnectServerShutdown                         // Server is shutting down
+	DisconnectKicked                                 // Server removed the client
+)
+
+// DisconnectCustom is the first reason code free for application use
+const DisconnectCustom DisconnectReason = 128
+
+const (
+	disconnectRedundancy = 3                     // DISCONNECT is sent this many times since it is never acked
+	lingerPollInterval   = 10 * time.Millisecond // How often Linger checks for pending packets
+)
+
+// String returns a readable name for the reason
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectNone:
+		return "none"
+	case DisconnectRequested:
+		return "requested"
+	case DisconnectTimeout:
+		return "timeout"
+	case DisconnectServerShutdown:
+		return "server shutdown"
+	case DisconnectKicked:
+		return "kicked"
+	default:
+		return fmt.Sprintf("custom(%d)", byte(r))
+	}
+}
+
+// CloseWithReason notifies the peer with a DISCONNECT carrying the reason and
+// optional payload, then closes the connection. If Config.Linger is set it first
+// waits up to that long for pending reliable packets to be acknowledged.
+func (c *Connection) CloseWithReason(reason DisconnectReason, payload []byte) error {
+	if len(payload) > c.maxPayload()-1 {
+		return ErrPacketTooLarge
+	}
+
+	c.mu.Lock()
+	if c.closed || c.closing {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closing = true
+	c.flushBatches()
+	c.mu.Unlock()
+
+	c.linger()
+
+	packet := &Packet{
+		Type:     DISCONNECT,
+		ClientID: c.clientID,
+		Data:     append([]byte{byte(reason)}, payload...),
+	}
+	addr := c.RemoteAddr()
+	for i := 0; i < disconnectRedundancy; i++ {
+		// Sealed separately so secure peers do not reject the copies as replays
+		c.conn.WriteToUDP(c.encode(packet), addr)
+	}
+
+	c.shutdown(reason, payload)
+	return nil
+}
+
+// linger waits until all reliable packets are acknowledged or Config.Linger elapses
+func (c *Connection) linger() {
+	if c.config.Linger <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(lingerPollInterval)
+	defer ticker.Stop()
+
+	deadline := time.Now().Add(c.config.Linger)
+	for time.Now().Before(deadline) {
+		c.mu.RLock()
+		pending := len(c.pendingAcks) + len(c.backlog)
+		c.mu.RUnlock()
+
+		if pending == 0 && len(c.outbound) == 0 {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// handleDisconnect closes the connection after the peer sent a DISCONNECT
+func (c *Connection) handleDisconnect(packet *Packet) {
+	reason := DisconnectRequested
+	var payload []byte
+	if len(packet.Data) > 0 {
+		reason = DisconnectReason(packet.Data[0])
+		payload = packet.Data[1:]
+	}
+	c.shutdown(reason, payload)
+}
+
+// shutdown closes the connection locally without notifying the peer
+func (c *Connection) shutdown(reason DisconnectReason, payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	c.closed = true
+	c.disconnectReason = reason
+	c.disconnectPayload = payload
+	if c.ackTimer != nil {
+		c.ackTimer.Stop()
+	}
+	if c.batchTimer != nil {
+		c.batchTimer.Stop()
+	}
+	c.failPending()
+	close(c.done)
+}
+
+// DisconnectReason returns why the connection was closed, or DisconnectNone while it is open
+func (c *Connection) DisconnectReason() DisconnectReason {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.disconnectReason
+}
+
+// DisconnectPayload returns the payload sent along with the disconnect, if any
+func (c *Connection) DisconnectPayload() []byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.disconnectPayload
+}
@@ -0,0 +1,82 @@
+package rudp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// newTestConnection creates a connection that is never started, for driving its
+// packet handling directly
+func newTestConnection(config Config) *Connection {
+	return newConnection(nil, nil, 1, config)
+}
+
+func TestHandleDisconnect(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		wantReason  DisconnectReason
+		wantPayload []byte
+	}{
+		{"no reason", nil, DisconnectRequested, nil},
+		{"reason", []byte{byte(DisconnectKicked)}, DisconnectKicked, []byte{}},
+		{"custom reason with payload", []byte{byte(DisconnectCustom + 1), 'b', 'y', 'e'}, DisconnectCustom + 1, []byte("bye")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConnection(DefaultConfig())
+			receipt := newReceipt()
+			c.mu.Lock()
+			c.admit(&Packet{Type: DATA, Mode: Reliable, receipt: receipt})
+			c.mu.Unlock()
+
+			if err := c.HandleIncomingPacket(&Packet{Type: DISCONNECT, Data: tt.data}); err != nil {
+				t.Fatalf("HandleIncomingPacket() error = %v", err)
+			}
+			if got := c.DisconnectReason(); got != tt.wantReason {
+				t.Errorf("DisconnectReason() = %v, want %v", got, tt.wantReason)
+			}
+			if got := c.DisconnectPayload(); !bytes.Equal(got, tt.wantPayload) {
+				t.Errorf("DisconnectPayload() = %q, want %q", got, tt.wantPayload)
+			}
+			if _, err := c.Receive(); !errors.Is(err, ErrConnectionClosed) {
+				t.Errorf("Receive() error = %v, want %v", err, ErrConnectionClosed)
+			}
+			if status := receipt.Status(); status != DeliveryFailed {
+				t.Errorf("pending message is %v, want %v", status, DeliveryFailed)
+			}
+			if err := c.Send([]byte("late"), Reliable); !errors.Is(err, ErrConnectionClosed) {
+				t.Errorf("Send() error = %v, want %v", err, ErrConnectionClosed)
+			}
+		})
+	}
+}
+
+func TestCloseWithReasonPayloadTooLarge(t *testing.T) {
+	c := newTestConnection(DefaultConfig())
+	if err := c.CloseWithReason(DisconnectKicked, make([]byte, c.maxPayload())); !errors.Is(err, ErrPacketTooLarge) {
+		t.Errorf("CloseWithReason() error = %v, want %v", err, ErrPacketTooLarge)
+	}
+	if c.DisconnectReason() != DisconnectNone {
+		t.Errorf("DisconnectReason() = %v, want the connection left open", c.DisconnectReason())
+	}
+}
+
+func TestDisconnectReasonString(t *testing.T) {
+	tests := []struct {
+		reason DisconnectReason
+		want   string
+	}{
+		{DisconnectNone, "none"},
+		{DisconnectTimeout, "timeout"},
+		{DisconnectServerShutdown, "server shutdown"},
+		{DisconnectCustom + 2, "custom(130)"},
+	}
+	for _, tt := range tests {
+		if got := tt.reason.String(); got != tt.want {
+			t.Errorf("DisconnectReason(%d).String() = %q, want %q", byte(tt.reason), got, tt.want)
+		}
+	}
+}
@@ -3,9 +3,25 @@ package rudp
 import "errors"
 
 var (
-	ErrInvalidPacket    = errors.New("invalid packet format")
-	ErrPacketTooLarge   = errors.New("packet exceeds maximum size")
-	ErrConnectionClosed = errors.New("connection is closed")
-	ErrTimeout          = errors.New("operation timed out")
-	ErrBufferFull       = errors.New("send buffer is full")
+	ErrInvalidPacket        = errors.New("invalid packet format")
+	ErrPacketTooLarge       = errors.New("packet exceeds maximum size")
+	ErrConnectionClosed     = errors.New("connection is closed")
+	ErrTimeout              = errors.New("operation timed out")
+	ErrBufferFull           = errors.New("send buffer is full")
+	ErrInvalidConfig        = errors.New("invalid configuration")
+	ErrNotReliable          = errors.New("delivery mode is not reliable")
+	ErrInvalidChannel       = errors.New("channel exceeds configured channel count")
+	ErrReassemblyFull       = errors.New("reassembly memory limit reached")
+	ErrInvalidCookie        = errors.New("invalid or expired connect cookie")
+	ErrKeyExchange          = errors.New("key exchange failed")
+	ErrAuthenticationFailed = errors.New("packet authentication failed")
+	ErrReplayed             = errors.New("replayed packet")
+	ErrClientIDInUse        = errors.New("client ID is in use by another session")
+	ErrTokenRequired        = errors.New("connect token required")
+	ErrInvalidProof         = errors.New("connect token session key proof failed")
+	ErrVersionMismatch      = errors.New("protocol version mismatch")
+	ErrChecksumMismatch     = errors.New("packet checksum mismatch")
+	ErrPathNotValidated     = errors.New("packet from an address the connection has not moved to")
+	ErrOrderWindow          = errors.New("ordered packet too far ahead of its stream")
+	ErrMigrationTooSoon     = errors.New("connection moved too recently to move again")
 )
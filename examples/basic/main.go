@@ -22,8 +22,8 @@ func main() {
 		conn.Send([]byte("Echo: "+string(packet.Data)), rudp.Reliable)
 	}
 
-	server.OnDisconnect = func(conn *rudp.Connection) {
-		fmt.Printf("Client disconnected: %s\n", conn.RemoteAddr())
+	server.OnDisconnect = func(conn *rudp.Connection, reason rudp.DisconnectReason) {
+		fmt.Printf("Client disconnected: %s (%s)\n", conn.RemoteAddr(), reason)
 	}
 
 	if err := server.Listen(":8080"); err != nil {
@@ -42,8 +42,8 @@ func main() {
 			fmt.Printf("Client received: %s\n", string(packet.Data))
 		}
 
-		client.OnDisconnect = func() {
-			fmt.Println("Client disconnected")
+		client.OnDisconnect = func(reason rudp.DisconnectReason) {
+			fmt.Printf("Client disconnected (%s)\n", reason)
 		}
 
 		if err := client.Connect("localhost:8080"); err != nil {
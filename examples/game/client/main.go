@@ -102,13 +102,6 @@ func main() {
 		}
 
 		switch msg.Type {
-		case types.MsgServerPlayerAssignment:
-			var assignment types.PlayerAssignment
-			if err := json.Unmarshal(msg.Data, &assignment); err != nil {
-				log.Printf("Failed to unmarshal player assignment: %v", err)
-				return
-			}
-			game.playerID = assignment.PlayerID
 		case types.MsgServerGameState:
 			var state types.GameState
 			if err := json.Unmarshal(msg.Data, &state); err != nil {
@@ -119,9 +112,20 @@ func main() {
 		}
 	}
 
-	if err := client.Connect("localhost:8080"); err != nil {
+	// The server assigns our player ID during the handshake
+	join, err := json.Marshal(types.JoinRequest{Version: types.GameVersion})
+	if err != nil {
+		log.Fatal(err)
+	}
+	reply, err := client.ConnectWithPayload("localhost:8080", join)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var assignment types.PlayerAssignment
+	if err := json.Unmarshal(reply, &assignment); err != nil {
 		log.Fatal(err)
 	}
+	game.playerID = assignment.PlayerID
 
 	ebiten.SetWindowSize(640, 480)
 	ebiten.SetWindowTitle("RUDP Game Demo")
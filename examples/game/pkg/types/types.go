@@ -5,8 +5,7 @@ import "encoding/json"
 type MessageType string
 
 const (
-	MsgServerPlayerAssignment MessageType = "player_assignment"
-	MsgServerGameState        MessageType = "game_state"
+	MsgServerGameState MessageType = "game_state"
 
 	MsgClientPlayerLogin  MessageType = "player_login"  // TODO: implement login
 	MsgClientPlayerLogout MessageType = "player_logout" // TODO: implement logout
@@ -29,6 +28,15 @@ type PlayerInput struct {
 	Y int `json:"y"`
 }
 
+// GameVersion must match between client and server for a client to be accepted
+const GameVersion = "1"
+
+// JoinRequest is sent by the client with its handshake
+type JoinRequest struct {
+	Version string `json:"version"`
+}
+
+// PlayerAssignment is returned by the server with its handshake
 type PlayerAssignment struct {
 	PlayerID string `json:"player_id"`
 }
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"net"
+	"sync"
 	"time"
 
 	"github.com/cbodonnell/rudp"
@@ -33,13 +35,49 @@ func generatePlayerID() string {
 }
 
 func main() {
-	server := rudp.NewServer()
+	// The game state grows with the player count, so let it be fragmented
+	// once it no longer fits in a single packet
+	config := rudp.DefaultConfig()
+	config.FragmentUnreliable = true
+	server, err := rudp.NewServerWithConfig(config)
+	if err != nil {
+		log.Fatal(err)
+	}
 	gameState := &types.GameState{Players: make(map[string]*types.Player)}
 	connToPlayer := make(map[string]string) // conn addr -> player ID
 
 	connEventCh := make(chan ConnectionEvent, 1000)
 	clientMsgCh := make(chan ClientMessage, 1000)
 
+	// Player IDs are assigned during the handshake and picked up by the game loop on connect
+	var assignedMu sync.Mutex
+	assigned := make(map[uint32]string) // client ID -> player ID
+
+	server.OnConnectRequest = func(addr *net.UDPAddr, clientID uint32, payload []byte) ([]byte, rudp.RejectReason) {
+		var join types.JoinRequest
+		if err := json.Unmarshal(payload, &join); err != nil || join.Version != types.GameVersion {
+			return nil, rudp.RejectDenied
+		}
+
+		playerID := generatePlayerID()
+		reply, err := json.Marshal(types.PlayerAssignment{PlayerID: playerID})
+		if err != nil {
+			log.Printf("Failed to marshal player assignment: %v", err)
+			return nil, rudp.RejectDenied
+		}
+
+		assignedMu.Lock()
+		assigned[clientID] = playerID
+		assignedMu.Unlock()
+		return reply, rudp.RejectNone
+	}
+
+	server.OnConnectAborted = func(addr *net.UDPAddr, clientID uint32) {
+		assignedMu.Lock()
+		delete(assigned, clientID)
+		assignedMu.Unlock()
+	}
+
 	server.OnConnect = func(conn *rudp.Connection) {
 		connEventCh <- ConnectionEvent{Conn: conn, Type: ConnectionEventConnect}
 	}
@@ -48,7 +86,7 @@ func main() {
 		clientMsgCh <- ClientMessage{Conn: conn, Packet: packet}
 	}
 
-	server.OnDisconnect = func(conn *rudp.Connection) {
+	server.OnDisconnect = func(conn *rudp.Connection, reason rudp.DisconnectReason) {
 		connEventCh <- ConnectionEvent{Conn: conn, Type: ConnectionEventDisconnect}
 	}
 
@@ -67,7 +105,10 @@ func main() {
 			switch event.Type {
 			case ConnectionEventConnect:
 				conn := event.Conn
-				playerID := generatePlayerID()
+				assignedMu.Lock()
+				playerID := assigned[conn.ClientID()]
+				delete(assigned, conn.ClientID())
+				assignedMu.Unlock()
 				connAddr := conn.RemoteAddr().String()
 				connToPlayer[connAddr] = playerID
 				p := &types.Player{ID: playerID, X: 100, Y: 100}
@@ -75,24 +116,6 @@ func main() {
 
 				fmt.Printf("Player joined: %s (ID: %s)\n", connAddr, playerID)
 
-				// Send player assignment
-				assignment := types.PlayerAssignment{PlayerID: playerID}
-				assignmentData, err := json.Marshal(assignment)
-				if err != nil {
-					log.Printf("Failed to marshal player assignment: %v", err)
-					continue
-				}
-				msg := types.Message{Type: types.MsgServerPlayerAssignment, Data: assignmentData}
-				msgData, err := json.Marshal(msg)
-				if err != nil {
-					log.Printf("Failed to marshal player assignment: %v", err)
-					continue
-				}
-				if err := conn.Send(msgData, rudp.Reliable); err != nil {
-					log.Printf("Failed to send player assignment: %v", err)
-					continue
-				}
-
 				// Send initial game state
 				stateData, err := json.Marshal(gameState)
 				if err != nil {
@@ -181,7 +204,8 @@ func main() {
 			log.Printf("Failed to marshal game state message: %v", err)
 			continue
 		}
-		if err := server.Broadcast(stateMsgData, rudp.Unreliable); err != nil {
+		// Only the newest state matters, so late snapshots are dropped rather than applied
+		if err := server.Broadcast(stateMsgData, rudp.UnreliableSequenced); err != nil {
 			log.Printf("Failed to broadcast game state: %v", err)
 			continue
 		}
@@ -0,0 +1,214 @@
+package rudp
+
+import (
+	"container/list"
+	"encoding/binary"
+	"time"
+)
+
+// FragmentHeaderSize is the size of the fragment header that prefixes the data of FRAGMENT packets
+const FragmentHeaderSize = 4 // FragmentID(2) + Index(1) + Count(1)
+
+// MaxFragmentCount is the most fragments the wire format allows a message to be split into
+const MaxFragmentCount = 255
+
+// Memory charged against MaxReassemblyBytes besides the chunks themselves, so that
+// messages of many tiny fragments cannot hold more than the limit
+const (
+	reassemblyOverhead = 256 // Bookkeeping of one incomplete message
+	chunkOverhead      = 24  // Slice header of one expected fragment
+)
+
+// reassembly collects the fragments of one message
+type reassembly struct {
+	mode          DeliveryMode
+	channel       uint8
+	orderSequence uint16
+	chunks        [][]byte
+	received      int
+	bytes         int // Bytes of the chunks received so far
+	started       time.Time
+	element       *list.Element // Position in the connection's expiry order
+}
+
+// reassemblyMemory is the memory charged for an incomplete message of count
+// fragments holding bytes of chunks
+func reassemblyMemory(count, bytes int) int {
+	return reassemblyOverhead + count*chunkOverhead + bytes
+}
+
+// memory is the memory charged for the message against MaxReassemblyBytes
+func (r *reassembly) memory() int {
+	return reassemblyMemory(len(r.chunks), r.bytes)
+}
+
+// matches reports whether a fragment belongs to the message being reassembled,
+// as opposed to an older message whose fragment ID has wrapped around
+func (r *reassembly) matches(packet *Packet, count uint8) bool {
+	return r.mode == packet.Mode && r.channel == packet.Channel &&
+		r.orderSequence == packet.OrderSequence && len(r.chunks) == int(count)
+}
+
+// parseFragmentHeader splits the data of a FRAGMENT packet into its header fields and chunk
+func parseFragmentHeader(data []byte) (id uint16, index, count uint8, chunk []byte, err error) {
+	if len(data) < FragmentHeaderSize {
+		return 0, 0, 0, nil, ErrInvalidPacket
+	}
+	id = binary.LittleEndian.Uint16(data[0:2])
+	index = data[2]
+	count = data[3]
+	if count == 0 || index >= count || len(data) == FragmentHeaderSize {
+		return 0, 0, 0, nil, ErrInvalidPacket
+	}
+	return id, index, count, data[FragmentHeaderSize:], nil
+}
+
+// fragment splits a message that does not fit in one packet into FRAGMENT packets.
+// Must be called with the lock held.
+func (c *Connection) fragment(message *Packet) ([]*Packet, error) {
+	if !message.IsReliable() && !c.config.FragmentUnreliable {
+		return nil, ErrPacketTooLarge
+	}
+
+	chunkSize := c.maxPayload() - FragmentHeaderSize
+	count := (len(message.Data) + chunkSize - 1) / chunkSize
+	if count > c.config.MaxFragments {
+		return nil, ErrPacketTooLarge
+	}
+
+	id := c.nextFragmentID
+	c.nextFragmentID++
+
+	fragments := make([]*Packet, 0, count)
+	for i := 0; i < count; i++ {
+		end := min((i+1)*chunkSize, len(message.Data))
+		chunk := message.Data[i*chunkSize : end]
+
+		data := make([]byte, FragmentHeaderSize+len(chunk))
+		binary.LittleEndian.PutUint16(data[0:2], id)
+		data[2] = byte(i)
+		data[3] = byte(count)
+		copy(data[FragmentHeaderSize:], chunk)
+
+		fragments = append(fragments, &Packet{
+			Type:      FRAGMENT,
+			ClientID:  message.ClientID,
+			Mode:      message.Mode,
+			Channel:   message.Channel,
+			Data:      data,
+			Timestamp: message.Timestamp,
+			receipt:   message.receipt,
+			message:   message,
+		})
+	}
+	return fragments, nil
+}
+
+// checkFragment validates a FRAGMENT packet and makes sure there is room to
+// reassemble it before it gets acked. Must be called with the lock held.
+func (c *Connection) checkFragment(packet *Packet, now time.Time) error {
+	id, index, count, chunk, err := parseFragmentHeader(packet.Data)
+	if err != nil {
+		return err
+	}
+	if int(count) > c.config.MaxFragments {
+		return ErrPacketTooLarge
+	}
+
+	c.expireReassemblies(now)
+
+	need := reassemblyMemory(int(count), len(chunk))
+	if r, exists := c.reassemblies[id]; exists {
+		switch {
+		case !r.matches(packet, count):
+			need -= r.memory() // The stale message is replaced
+		case r.chunks[index] != nil:
+			return nil
+		default:
+			need = len(chunk)
+		}
+	}
+	if c.reassemblyBytes+need > c.config.MaxReassemblyBytes {
+		return ErrReassemblyFull
+	}
+	return nil
+}
+
+// reassemble stores a fragment and returns the complete message once all its
+// fragments have arrived, or nil until then. Must be called with the lock held.
+func (c *Connection) reassemble(packet *Packet, now time.Time) *Packet {
+	id, index, count, chunk, err := parseFragmentHeader(packet.Data)
+	if err != nil {
+		return nil
+	}
+
+	r, exists := c.reassemblies[id]
+	if !exists || !r.matches(packet, count) {
+		if exists {
+			c.dropReassembly(id, r)
+		}
+		r = &reassembly{
+			mode:          packet.Mode,
+			channel:       packet.Channel,
+			orderSequence: packet.OrderSequence,
+			chunks:        make([][]byte, count),
+			started:       now,
+		}
+		r.element = c.reassemblyOrder.PushBack(id)
+		c.reassemblies[id] = r
+		c.reassemblyBytes += r.memory()
+	}
+
+	if r.chunks[index] == nil {
+		r.chunks[index] = chunk
+		r.received++
+		r.bytes += len(chunk)
+		c.reassemblyBytes += len(chunk)
+	}
+
+	if r.received < len(r.chunks) {
+		return nil
+	}
+
+	c.dropReassembly(id, r)
+
+	data := make([]byte, 0, r.bytes)
+	for _, chunk := range r.chunks {
+		data = append(data, chunk...)
+	}
+
+	return &Packet{
+		Type:          DATA,
+		ClientID:      packet.ClientID,
+		Sequence:      packet.Sequence,
+		Ack:           packet.Ack,
+		AckBits:       packet.AckBits,
+		Mode:          r.mode,
+		Channel:       r.channel,
+		OrderSequence: r.orderSequence,
+		Timestamp:     packet.Timestamp,
+		Data:          data,
+	}
+}
+
+// expireReassemblies drops messages whose fragments did not all arrive within
+// FragmentTimeout. Messages are kept in the order they started, so only the
+// expired ones are visited. Must be called with the lock held.
+func (c *Connection) expireReassemblies(now time.Time) {
+	for front := c.reassemblyOrder.Front(); front != nil; front = c.reassemblyOrder.Front() {
+		id := front.Value.(uint16)
+		r := c.reassemblies[id]
+		if now.Sub(r.started) <= c.config.FragmentTimeout {
+			return
+		}
+		c.dropReassembly(id, r)
+	}
+}
+
+// dropReassembly forgets an incomplete message and releases its memory.
+// Must be called with the lock held.
+func (c *Connection) dropReassembly(id uint16, r *reassembly) {
+	delete(c.reassemblies, id)
+	c.reassemblyOrder.Remove(r.element)
+	c.reassemblyBytes -= r.memory()
+}
@@ -0,0 +1,242 @@
+package rudp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fragmentPacket builds a FRAGMENT packet with the given header fields
+func fragmentPacket(id uint16, index, count uint8, chunk []byte) *Packet {
+	data := binary.LittleEndian.AppendUint16(nil, id)
+	data = append(data, index, count)
+	return &Packet{Type: FRAGMENT, Mode: Reliable, Data: append(data, chunk...)}
+}
+
+func TestFragmentReassemble(t *testing.T) {
+	c := newTestConnection(DefaultConfig())
+	chunkSize := c.maxPayload() - FragmentHeaderSize
+
+	tests := []struct {
+		name      string
+		size      int
+		fragments int
+	}{
+		{"just over one packet", c.maxPayload() + 1, 2},
+		{"exact chunks", 3 * chunkSize, 3},
+		{"partial last chunk", 3*chunkSize + 10, 4},
+		{"most fragments", DefaultMaxFragments * chunkSize, DefaultMaxFragments},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make([]byte, tt.size)
+			for i := range data {
+				data[i] = byte(i)
+			}
+			message := &Packet{Type: DATA, Mode: ReliableOrdered, Channel: 2, OrderSequence: 7, Data: data}
+
+			fragments, err := c.fragment(message)
+			if err != nil {
+				t.Fatalf("fragment() error = %v", err)
+			}
+			if len(fragments) != tt.fragments {
+				t.Fatalf("got %d fragments, want %d", len(fragments), tt.fragments)
+			}
+
+			// Deliver them in reverse, as the network may
+			now := time.Now()
+			var got *Packet
+			for i := len(fragments) - 1; i >= 0; i-- {
+				fragment := fragments[i]
+				fragment.OrderSequence = message.OrderSequence
+				if len(fragment.Data) > c.maxPayload() {
+					t.Fatalf("fragment %d is %d bytes, over the %d byte payload", i, len(fragment.Data), c.maxPayload())
+				}
+				if err := c.checkFragment(fragment, now); err != nil {
+					t.Fatalf("checkFragment() error = %v", err)
+				}
+				got = c.reassemble(fragment, now)
+				if (got != nil) != (i == 0) {
+					t.Fatalf("reassemble() after fragment %d returned %v", i, got)
+				}
+			}
+			if !bytes.Equal(got.Data, data) || got.Mode != message.Mode || got.Channel != message.Channel ||
+				got.OrderSequence != message.OrderSequence {
+				t.Errorf("reassembled message does not match the original")
+			}
+			if c.reassemblyBytes != 0 || len(c.reassemblies) != 0 || c.reassemblyOrder.Len() != 0 {
+				t.Errorf("reassembly state left behind: %d bytes, %d messages", c.reassemblyBytes, len(c.reassemblies))
+			}
+		})
+	}
+}
+
+func TestFragmentTooLarge(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxFragments = 4
+	c := newTestConnection(config)
+	chunkSize := c.maxPayload() - FragmentHeaderSize
+
+	tests := []struct {
+		name    string
+		mode    DeliveryMode
+		size    int
+		wantErr error
+	}{
+		{"within limit", Reliable, 4 * chunkSize, nil},
+		{"over limit", Reliable, 4*chunkSize + 1, ErrPacketTooLarge},
+		{"unreliable", Unreliable, 2 * chunkSize, ErrPacketTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := c.fragment(&Packet{Type: DATA, Mode: tt.mode, Data: make([]byte, tt.size)})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("fragment() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckFragment(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxFragments = 8
+	config.MaxReassemblyBytes = 1500 // Room for one message of three 400 byte fragments and its bookkeeping
+	chunk := make([]byte, 400)
+
+	tests := []struct {
+		name    string
+		stored  []*Packet // Fragments reassembled beforehand
+		packet  *Packet
+		wantErr error
+	}{
+		{"valid", nil, fragmentPacket(1, 0, 2, chunk), nil},
+		{"short header", nil, &Packet{Type: FRAGMENT, Mode: Reliable, Data: []byte{1, 0, 0}}, ErrInvalidPacket},
+		{"zero count", nil, fragmentPacket(1, 0, 0, chunk), ErrInvalidPacket},
+		{"index past count", nil, fragmentPacket(1, 2, 2, chunk), ErrInvalidPacket},
+		{"empty chunk", nil, fragmentPacket(1, 0, 2, nil), ErrInvalidPacket},
+		{"too many fragments", nil, fragmentPacket(1, 0, 9, chunk), ErrPacketTooLarge},
+		{"fits memory", []*Packet{fragmentPacket(1, 0, 3, chunk)}, fragmentPacket(1, 1, 3, chunk), nil},
+		{"memory full", []*Packet{fragmentPacket(1, 0, 3, chunk), fragmentPacket(1, 1, 3, chunk)},
+			fragmentPacket(2, 0, 2, chunk), ErrReassemblyFull},
+		{"duplicate when full", []*Packet{fragmentPacket(1, 0, 3, chunk), fragmentPacket(1, 1, 3, chunk)},
+			fragmentPacket(1, 1, 3, chunk), nil},
+		{"replaces stale message when full", []*Packet{fragmentPacket(1, 0, 3, chunk), fragmentPacket(1, 1, 3, chunk)},
+			fragmentPacket(1, 0, 2, chunk), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConnection(config)
+			now := time.Now()
+			for _, packet := range tt.stored {
+				c.reassemble(packet, now)
+			}
+			if err := c.checkFragment(tt.packet, now); !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkFragment() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReassemblyTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.FragmentTimeout = time.Second
+	config.MaxReassemblyBytes = 1000
+	chunk := make([]byte, 600)
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		wantErr error
+		kept    int // Incomplete messages left afterwards
+	}{
+		{"before timeout", time.Second, ErrReassemblyFull, 1},
+		{"after timeout", time.Second + time.Millisecond, nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConnection(config)
+			start := time.Now()
+			c.reassemble(fragmentPacket(1, 0, 2, chunk), start)
+
+			// The stale message holds the memory another one needs until it expires
+			err := c.checkFragment(fragmentPacket(2, 0, 2, chunk), start.Add(tt.elapsed))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkFragment() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(c.reassemblies) != tt.kept {
+				t.Errorf("%d incomplete messages kept, want %d", len(c.reassemblies), tt.kept)
+			}
+			if tt.kept == 0 && c.reassemblyBytes != 0 {
+				t.Errorf("reassemblyBytes = %d after expiry, want 0", c.reassemblyBytes)
+			}
+		})
+	}
+}
+
+func TestReassemblyFlood(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxFragments = MaxFragmentCount
+	c := newTestConnection(config)
+	start := time.Now()
+
+	// Tiny first fragments of ever new messages may only hold MaxReassemblyBytes,
+	// bookkeeping included, however many of them arrive
+	stored := 0
+	for id := 0; id <= 0xFFFF; id++ {
+		packet := fragmentPacket(uint16(id), 0, MaxFragmentCount, []byte{1})
+		if err := c.checkFragment(packet, start); err != nil {
+			if !errors.Is(err, ErrReassemblyFull) {
+				t.Fatalf("checkFragment() error = %v, want %v", err, ErrReassemblyFull)
+			}
+			continue
+		}
+		c.reassemble(packet, start)
+		stored++
+	}
+	if want := config.MaxReassemblyBytes / reassemblyMemory(MaxFragmentCount, 1); stored != want {
+		t.Errorf("%d incomplete messages stored, want %d", stored, want)
+	}
+	if c.reassemblyBytes > config.MaxReassemblyBytes || c.reassemblyBytes != stored*reassemblyMemory(MaxFragmentCount, 1) {
+		t.Errorf("reassemblyBytes = %d for %d messages, limit %d", c.reassemblyBytes, stored, config.MaxReassemblyBytes)
+	}
+
+	// Empty fragments are refused outright
+	if err := c.checkFragment(fragmentPacket(0xFFFF, 0, MaxFragmentCount, nil), start); !errors.Is(err, ErrInvalidPacket) {
+		t.Errorf("empty fragment: checkFragment() error = %v, want %v", err, ErrInvalidPacket)
+	}
+
+	// All of them expire together, releasing the memory
+	if err := c.checkFragment(fragmentPacket(0, 1, MaxFragmentCount, []byte{1}), start.Add(config.FragmentTimeout+time.Millisecond)); err != nil {
+		t.Errorf("checkFragment() after timeout error = %v", err)
+	}
+	if c.reassemblyBytes != 0 || len(c.reassemblies) != 0 || c.reassemblyOrder.Len() != 0 {
+		t.Errorf("reassembly state left after timeout: %d bytes, %d messages", c.reassemblyBytes, len(c.reassemblies))
+	}
+}
+
+func TestReassemblyExpiryOrder(t *testing.T) {
+	config := DefaultConfig()
+	config.FragmentTimeout = time.Second
+	c := newTestConnection(config)
+	start := time.Now()
+	chunk := make([]byte, 10)
+
+	c.reassemble(fragmentPacket(1, 0, 2, chunk), start)
+	c.reassemble(fragmentPacket(2, 0, 2, chunk), start.Add(time.Second/2))
+	// Replacing message 1 after its fragment ID wrapped around restarts its timeout
+	c.reassemble(fragmentPacket(1, 0, 3, chunk), start.Add(time.Second/2+1))
+
+	c.expireReassemblies(start.Add(time.Second + time.Second/2 + 1))
+	if _, kept := c.reassemblies[2]; kept || len(c.reassemblies) != 1 {
+		t.Errorf("kept %d messages, want only the replaced message 1", len(c.reassemblies))
+	}
+	if want := reassemblyMemory(3, len(chunk)); c.reassemblyBytes != want {
+		t.Errorf("reassemblyBytes = %d, want %d", c.reassemblyBytes, want)
+	}
+}
@@ -3,15 +3,35 @@
 extends Node
 class_name RUDPClient
 
+# RejectReason describes why a server refused a connection (matching Go reject.go)
+enum RejectReason {
+	NONE = 0,
+	SERVER_FULL = 1,
+	DENIED = 2,
+	INVALID_TOKEN = 3,
+	VERSION_MISMATCH = 4,
+	APP_MISMATCH = 5,
+	SESSION_EXPIRED = 6
+}
+
+# Handshake constants (matching Go handshake.go and config.go)
+const CONNECT_PADDING = 24  # CONNECT is padded to the size of the cookie in CONNECT_CHALLENGE
+const HANDSHAKE_INTERVAL_MS = 250  # How often an unanswered handshake packet is resent
+const HANDSHAKE_TIMEOUT_MS = 5000
+const PATH_KEY_LABEL = "rudp path"  # Label of the key answering path challenges
+
 # Client represents a UDP client connection (matching Go struct)
 var _conn: PacketPeerUDP = null
 var _connection: RUDPConnection = null
 var _client_id: int = 0      # uint32
+var app_id: int = 0          # uint32 - Must match the server's Config.AppID
 var _connected: bool = false
+var _reject_reason: int = RejectReason.NONE  # Why the server refused the last connection attempt
+var _handshake_reply: PackedByteArray = PackedByteArray()  # Payload the server replied with during the handshake
 
 # Events (matching Go callbacks)
 var on_message: Callable  # func(packet: RUDPPacket)
-var on_disconnect: Callable  # func()
+var on_disconnect: Callable  # func(reason: int)
 
 # State
 var _done: bool = false
@@ -24,8 +44,9 @@ func _init():
 static func generate_client_id() -> int:
 	return randi() & 0xFFFFFFFF  # Generate random 32-bit unsigned integer
 
-## Connect establishes a connection to the server (matching Go client.go:41)
-func connect_to_server(addr: String) -> int:
+## Connect establishes a connection to the server, sending an optional handshake payload (matching Go client.go ConnectWithPayload)
+## The server's reply payload is available from get_handshake_reply() afterwards
+func connect_to_server(addr: String, payload: PackedByteArray = PackedByteArray()) -> int:
 	# Parse address (expecting "host:port")
 	var parts = addr.split(":")
 	if parts.size() != 2:
@@ -47,44 +68,101 @@ func connect_to_server(addr: String) -> int:
 	_connection = RUDPConnection.new(_conn, host, port, _client_id)
 
 	# Perform handshake BEFORE starting background processing (matching Go client.go:54)
-	err = perform_handshake()
+	err = perform_handshake(payload)
 	if err != OK:
-		disconnect_from_server()
+		_connection.shutdown(RUDPConnection.DisconnectReason.NONE, PackedByteArray())
+		release()
 		return err
 
 	return OK
 
-## performHandshake sends CONNECT and waits for CONNECT_ACK (matching Go client.go:68)
-func perform_handshake() -> Error:
-	var connect_packet = RUDPPacket.new()
-	connect_packet.type = RUDPPacket.PacketType.CONNECT
-	connect_packet.client_id = _client_id
-	connect_packet.data = PackedByteArray()
-
-	# Send CONNECT packet (matching Go client.go:75)
-	var data = connect_packet.marshal()
-	_conn.put_packet(data)
-
-	# Wait for CONNECT_ACK with timeout (no other goroutines reading yet)
-	var deadline = Time.get_ticks_msec() + 5000  # 5 second timeout
+## Secure mode, connect tokens and checksums (Go Config.Secure, Config.TokenSecret and Config.Checksum) are not supported, the server must not enable them
+## Session resumption is not supported either; the server's Config.ResumeGracePeriod only delays noticing that the client is gone
+## performHandshake sends CONNECT, answers CONNECT_CHALLENGE with CONNECT_RESPONSE and waits for CONNECT_ACK (matching Go client.go)
+func perform_handshake(payload: PackedByteArray) -> Error:
+	# CONNECT carries the app id and is padded so the server's challenge is never larger than the request (matching Go handshake.go)
+	var request = RUDPPacket.new()
+	request.type = RUDPPacket.PacketType.CONNECT
+	request.client_id = _client_id
+	request.data = PackedByteArray()
+	request.data.resize(CONNECT_PADDING)
+	request.data.encode_u32(0, app_id)
+
+	# Wait for replies with timeout, resending unanswered handshake packets (no other goroutines reading yet)
+	var deadline = Time.get_ticks_msec() + HANDSHAKE_TIMEOUT_MS
+	var last_sent = -HANDSHAKE_INTERVAL_MS
 	var buffer: PackedByteArray
 
 	while Time.get_ticks_msec() < deadline:
+		if Time.get_ticks_msec() - last_sent >= HANDSHAKE_INTERVAL_MS:
+			_conn.put_packet(request.marshal())
+			last_sent = Time.get_ticks_msec()
+
 		if _conn.get_available_packet_count() > 0:
 			buffer = _conn.get_packet()
 
 			var packet = RUDPPacket.new()
-			if packet.unmarshal(buffer) != OK:
+			var unmarshal_err = packet.unmarshal(buffer)
+			if unmarshal_err == ERR_INVALID_DATA and packet.client_id == _client_id:
+				print("Server speaks protocol version %d, client speaks version %d" % [buffer[2], RUDPPacket.PROTOCOL_VERSION])
+				return ERR_INVALID_DATA  # ErrVersionMismatch
+			if unmarshal_err != OK:
 				print("Failed to unmarshal packet during handshake")
 				continue
+			if packet.client_id != _client_id:
+				continue
 
-			if packet.type == RUDPPacket.PacketType.CONNECT_ACK and packet.client_id == _client_id:
+			if packet.type == RUDPPacket.PacketType.CONNECT_CHALLENGE:
+				# Answer immediately, then keep resending the response instead of CONNECT
+				request = RUDPPacket.new()
+				request.type = RUDPPacket.PacketType.CONNECT_RESPONSE
+				request.client_id = _client_id
+				# Cookie followed by length-prefixed public key, token, proof and payload (matching Go handshake.go)
+				request.data = packet.data.duplicate()
+				for field in [PackedByteArray(), PackedByteArray(), PackedByteArray(), payload]:
+					request.data.append_array(_length_prefixed(field))
+				last_sent = -HANDSHAKE_INTERVAL_MS
+			elif packet.type == RUDPPacket.PacketType.CONNECT_REJECT:
+				_reject_reason = packet.data[0] if packet.data.size() > 0 else RejectReason.DENIED
+				return ERR_UNAUTHORIZED  # RejectedError, reason in get_reject_reason()
+			elif packet.type == RUDPPacket.PacketType.CONNECT_ACK:
+				# Length-prefixed public key, payload and connection token (matching Go handshake.go connectAck)
+				var fields = _parse_fields(packet.data, 3)
+				_handshake_reply = fields[1]
+				_connection.path_key = _connection_key(fields[2], PATH_KEY_LABEL)
 				_connected = true
 				print("Connected to server with clientID: %d" % _client_id)
 				return OK
 
 	return ERR_TIMEOUT  # Handshake timeout: no CONNECT_ACK received
 
+## _length_prefixed serializes a handshake field with its length (matching Go handshake.go appendFields)
+static func _length_prefixed(field: PackedByteArray) -> PackedByteArray:
+	var buf = PackedByteArray()
+	buf.resize(2)
+	buf.encode_u16(0, field.size())
+	buf.append_array(field)
+	return buf
+
+## _parse_fields deserializes length-prefixed handshake fields, leaving missing ones empty (matching Go handshake.go parseFields)
+static func _parse_fields(data: PackedByteArray, count: int) -> Array:
+	var fields = []
+	var offset = 0
+	for i in range(count):
+		var field = PackedByteArray()
+		if data.size() >= offset + 2:
+			var size = data.decode_u16(offset)
+			field = data.slice(offset + 2, offset + 2 + size)
+			offset += 2 + size
+		fields.append(field)
+	return fields
+
+## _connection_key derives a key from the connection token of CONNECT_ACK (matching Go handshake.go connectionKey without secure mode)
+static func _connection_key(token: PackedByteArray, label: String) -> PackedByteArray:
+	if token.is_empty():
+		return PackedByteArray()
+	return RUDPConnection.hmac_sha256(token, label.to_utf8_buffer())
+
 ## handle_packets reads incoming UDP packets (matching Go client.go:112)
 ## This would be called in _process() since GDScript doesn't use goroutines
 func handle_packets() -> void:
@@ -97,7 +175,7 @@ func handle_packets() -> void:
 			continue
 
 		# Ignore handshake packets (already handled during Connect) (matching Go client.go:136)
-		if packet.type == RUDPPacket.PacketType.CONNECT or packet.type == RUDPPacket.PacketType.CONNECT_ACK:
+		if packet.type == RUDPPacket.PacketType.CONNECT_ACK or packet.type == RUDPPacket.PacketType.CONNECT_CHALLENGE or packet.type == RUDPPacket.PacketType.CONNECT_REJECT:
 			continue
 
 		_connection.handle_incoming_packet(packet)
@@ -111,10 +189,11 @@ func handle_connection() -> void:
 			on_message.call(packet)
 		return
 
-	# Check if connection closed
+	# Check if connection closed, either locally or by the server
 	if not _connection.is_connection_active():
+		release()
 		if on_disconnect:
-			on_disconnect.call()
+			on_disconnect.call(_connection.get_disconnect_reason())
 
 ## Send transmits data to the server (matching Go client.go:169)
 func send(data: PackedByteArray, mode: int) -> int:
@@ -122,25 +201,54 @@ func send(data: PackedByteArray, mode: int) -> int:
 		return ERR_UNCONFIGURED  # ErrConnectionClosed
 	return _connection.send(data, mode)
 
+## SendOnChannel transmits data to the server on the given channel (matching Go client.go)
+func send_on_channel(channel: int, data: PackedByteArray, mode: int) -> int:
+	if _connection == null:
+		return ERR_UNCONFIGURED  # ErrConnectionClosed
+	return _connection.send_on_channel(channel, data, mode)
+
 ## IsConnected returns true if connected to server (matching Go client.go:177)
 func is_connected_to_server() -> bool:
 	return _connected and _connection != null and _connection.is_connection_active()
 
+## HandshakeReply returns the payload the server replied with during the handshake (matching Go ConnectWithPayload)
+func get_handshake_reply() -> PackedByteArray:
+	return _handshake_reply
+
+## RejectReason returns why the server refused the last connection attempt (matching Go RejectedError)
+func get_reject_reason() -> int:
+	return _reject_reason
+
 ## ClientID returns the client's unique identifier (matching Go client.go:182)
 func get_client_id() -> int:
 	return _client_id
 
-## Close disconnects from the server (matching Go client.go:187)
+## Close disconnects from the server (matching Go client.go Close)
 func disconnect_from_server() -> int:
-	_done = true
+	return disconnect_with_reason(RUDPConnection.DisconnectReason.REQUESTED, PackedByteArray())
 
+## CloseWithReason disconnects from the server, sending it the reason and optional payload (matching Go client.go)
+func disconnect_with_reason(reason: int, payload: PackedByteArray) -> int:
 	if _connection != null:
-		_connection.close()
+		var err = _connection.close_with_reason(reason, payload)
+		if err != OK:
+			return err
+	return release()
 
-	if _conn != null:
-		_conn.close()
+## DisconnectReason returns why the client was disconnected (matching Go client.go)
+func get_disconnect_reason() -> int:
+	if _connection == null:
+		return RUDPConnection.DisconnectReason.NONE
+	return _connection.get_disconnect_reason()
+
+## release stops processing and closes the socket (matching Go client.go release)
+func release() -> int:
+	if _done:
 		return OK
 
+	_done = true
+	if _conn != null:
+		_conn.close()
 	return OK
 
 ## RemoteAddr returns the server address (matching Go client.go:201)
@@ -164,5 +272,11 @@ func _process(_delta: float) -> void:
 	# Check for retransmissions (replaces processRetransmissions goroutine)
 	_connection.check_retransmissions()
 
+	# Send keepalives and detect timeouts (replaces processHeartbeats goroutine)
+	_connection.check_heartbeat()
+
+	# Send delayed standalone acks (replaces the ack timer)
+	_connection.check_ack()
+
 	# Handle application packets (replaces handleConnection goroutine)
 	handle_connection()
@@ -3,8 +3,32 @@
 extends RefCounted
 class_name RUDPConnection
 
-# Constants (matching Go connection.go)
+# Constants (matching Go config.go defaults)
 const INACTIVITY_TIMEOUT = 5.0  # seconds
+const HEARTBEAT_INTERVAL = 1.0  # seconds
+const ACK_DELAY = 0.01  # seconds
+const CHANNELS = 16  # Number of logical channels, numbered from 0
+const DISCONNECT_REDUNDANCY = 3  # DISCONNECT is sent this many times since it is never acked
+const FRAGMENT_HEADER_SIZE = 4  # FragmentID(2) + Index(1) + Count(1)
+const MAX_FRAGMENTS = 64  # Most fragments a message may be split into
+const FRAGMENT_TIMEOUT = 5.0  # seconds
+const MAX_REASSEMBLY_BYTES = 1 << 20  # Memory held in incomplete messages, counting their bookkeeping
+const REASSEMBLY_OVERHEAD = 256  # Bookkeeping of one incomplete message
+const CHUNK_OVERHEAD = 24  # Bookkeeping of one expected fragment
+const PATH_PROOF_SIZE = 16  # Truncated HMAC answering a path challenge
+const BATCH_ENTRY_HEADER_SIZE = 6  # Mode(1) + Channel(1) + OrderSeq(2) + Size(2)
+const MTU_PROBE_ID_SIZE = 2  # Probe ID echoed by MTU_PROBE_ACK
+const ORDER_WINDOW = 1024  # Packets a stream buffers ahead of the next expected one
+const ORDER_HOLD = 0.1  # seconds an unreliable stream waits for a missing packet
+
+# DisconnectReason describes why a connection was closed (matching Go disconnect.go)
+enum DisconnectReason {
+	NONE = 0,
+	REQUESTED = 1,
+	TIMEOUT = 2,
+	SERVER_SHUTDOWN = 3,
+	KICKED = 4
+}
 
 # Connection represents a reliable UDP connection to a peer (matching Go struct)
 var _addr: String = ""  # Remote IP
@@ -13,21 +37,32 @@ var _conn: PacketPeerUDP = null
 
 # Identity (matching Go)
 var _client_id: int = 0  # uint32
+var path_key: PackedByteArray = PackedByteArray()  # Key proving the right to move the connection to a new address
 
 # Sequence tracking (matching Go)
 var _local_sequence: int = 0   # uint16
-var _remote_sequence: int = 0  # uint16
+var _remote_sequence: int = 0xFFFF  # uint16, acks sent before anything is received must not acknowledge the peer's first sequence
 var _ack_bits: int = 0         # uint32
+var _received_any: bool = false  # Whether _remote_sequence holds a received sequence yet
+var _ack_pending_since: float = -1.0  # When received reliable packets started awaiting an ack, -1 if none
 
 # Reliability (matching Go)
 var _pending_acks: Dictionary = {}    # map[uint16]*Packet
-var _ordered_buffer: Dictionary = {}  # map[uint16]*Packet
+var _streams: Dictionary = {}         # map[streamKey]*orderedStream keyed by channel * 256 + mode, each {next_send, next_recv, buffer, held_since}
+
+# Fragmentation (matching Go fragment.go; only receiving is supported)
+var _reassemblies: Dictionary = {}    # map[uint16]*reassembly, each {mode, channel, order_sequence, chunks, received, bytes, started}, in the order they started
+var _reassembly_bytes: int = 0
 
 # State (matching Go)
 var _last_received: float = 0.0  # Time.get_ticks_msec() / 1000.0
 var _last_sent: float = 0.0
 var _closed: bool = false
 
+# Disconnect (matching Go)
+var _disconnect_reason: int = DisconnectReason.NONE
+var _disconnect_payload: PackedByteArray = PackedByteArray()
+
 # Channels (emulated as queues since GDScript doesn't have goroutines)
 var _inbound: Array = []   # chan *Packet (buffered 256)
 var _outbound: Array = []  # chan *Packet (buffered 256)
@@ -41,12 +76,20 @@ func _init(conn: PacketPeerUDP, address: String, port: int, client_id: int):
 	_port = port
 	_client_id = client_id
 	_last_received = Time.get_ticks_msec() / 1000.0
+	_last_sent = _last_received
 
-## Send queues a packet for transmission (matching Go connection.go:65)
+## Send queues a packet for transmission on the default channel 0 (matching Go connection.go)
 func send(data: PackedByteArray, mode: int) -> int:
+	return send_on_channel(0, data, mode)
+
+## SendOnChannel queues a packet for transmission on the given channel (matching Go connection.go)
+func send_on_channel(channel: int, data: PackedByteArray, mode: int) -> int:
 	if _closed:
 		return ERR_UNCONFIGURED  # ErrConnectionClosed
 
+	if channel < 0 or channel >= CHANNELS:
+		return ERR_INVALID_PARAMETER  # ErrInvalidChannel
+
 	if data.size() > RUDPPacket.MAX_PACKET_SIZE - RUDPPacket.HEADER_SIZE:
 		return ERR_INVALID_PARAMETER  # ErrPacketTooLarge
 
@@ -57,10 +100,17 @@ func send(data: PackedByteArray, mode: int) -> int:
 	packet.ack = _remote_sequence
 	packet.ack_bits = _ack_bits
 	packet.mode = mode
+	packet.channel = channel
 	packet.data = data
 	packet.timestamp = Time.get_ticks_msec()
 
+	if packet.is_ordered():
+		var stream = get_stream(channel, mode)
+		packet.order_sequence = stream.next_send
+		stream.next_send = (stream.next_send + 1) & 0xFFFF
+
 	_local_sequence = (_local_sequence + 1) & 0xFFFF
+	_ack_pending_since = -1.0
 
 	if packet.is_reliable():
 		_pending_acks[packet.sequence] = packet
@@ -80,14 +130,57 @@ func receive() -> RUDPPacket:
 		return null  # ErrConnectionClosed
 	return null  # No packet available
 
-## Close closes the connection (matching Go connection.go:113)
+## Close notifies the peer and closes the connection (matching Go connection.go Close)
 func close() -> int:
+	return close_with_reason(DisconnectReason.REQUESTED, PackedByteArray())
+
+## CloseWithReason notifies the peer with a DISCONNECT and closes the connection (matching Go disconnect.go)
+## Linger is not supported since GDScript cannot block while acks arrive
+func close_with_reason(reason: int, payload: PackedByteArray) -> int:
+	if payload.size() > RUDPPacket.MAX_PACKET_SIZE - RUDPPacket.HEADER_SIZE - 1:
+		return ERR_INVALID_PARAMETER  # ErrPacketTooLarge
+
 	if _closed:
 		return OK
 
-	_closed = true
+	var packet = RUDPPacket.new()
+	packet.type = RUDPPacket.PacketType.DISCONNECT
+	packet.client_id = _client_id
+	packet.data = PackedByteArray([reason]) + payload
+
+	var data = packet.marshal()
+	for i in range(DISCONNECT_REDUNDANCY):
+		_conn.put_packet(data)
+
+	shutdown(reason, payload)
 	return OK
 
+## handle_disconnect closes the connection after the peer sent a DISCONNECT (matching Go disconnect.go)
+func handle_disconnect(packet: RUDPPacket) -> void:
+	var reason = DisconnectReason.REQUESTED
+	var payload = PackedByteArray()
+	if packet.data.size() > 0:
+		reason = packet.data[0]
+		payload = packet.data.slice(1)
+	shutdown(reason, payload)
+
+## shutdown closes the connection locally without notifying the peer (matching Go disconnect.go)
+func shutdown(reason: int, payload: PackedByteArray) -> void:
+	if _closed:
+		return
+
+	_closed = true
+	_disconnect_reason = reason
+	_disconnect_payload = payload
+
+## DisconnectReason returns why the connection was closed (matching Go disconnect.go)
+func get_disconnect_reason() -> int:
+	return _disconnect_reason
+
+## DisconnectPayload returns the payload sent along with the disconnect (matching Go disconnect.go)
+func get_disconnect_payload() -> PackedByteArray:
+	return _disconnect_payload
+
 ## IsConnected returns true if the connection is active (matching Go connection.go:127)
 func is_connection_active() -> bool:
 	var now = Time.get_ticks_msec() / 1000.0
@@ -131,6 +224,8 @@ func check_retransmissions() -> void:
 		if now - packet.last_sent > RUDPReliability.RETRANSMISSION_TIMEOUT:
 			if packet.attempts >= RUDPReliability.MAX_RETRANSMISSIONS:
 				to_remove.append(seq)
+				if packet.mode == RUDPPacket.DeliveryMode.RELIABLE_ORDERED:
+					queue_order_skip(packet)
 				continue
 
 			# Resend packet (matching Go: c.outbound <- packet)
@@ -140,23 +235,316 @@ func check_retransmissions() -> void:
 	for seq in to_remove:
 		_pending_acks.erase(seq)
 
+## queue_order_skip tells the server to move past a RELIABLE_ORDERED message that was given up on (matching Go stream.go)
+## The ORDER_SKIP is itself reliable and ordered, taking the message's place in its stream
+func queue_order_skip(message: RUDPPacket) -> void:
+	var packet = RUDPPacket.new()
+	packet.type = RUDPPacket.PacketType.ORDER_SKIP
+	packet.client_id = _client_id
+	packet.sequence = _local_sequence
+	packet.ack = _remote_sequence
+	packet.ack_bits = _ack_bits
+	packet.mode = RUDPPacket.DeliveryMode.RELIABLE_ORDERED
+	packet.channel = message.channel
+	packet.order_sequence = message.order_sequence
+	packet.timestamp = Time.get_ticks_msec()
+
+	_local_sequence = (_local_sequence + 1) & 0xFFFF
+	_ack_pending_since = -1.0
+	_pending_acks[packet.sequence] = packet
+	if _outbound.size() < CHANNEL_BUFFER_SIZE:
+		_outbound.append(packet)
+
 ## handle_incoming_packet processes received packets (matching Go reliability.go:74)
 func handle_incoming_packet(packet: RUDPPacket) -> int:
+	match packet.type:
+		RUDPPacket.PacketType.DATA, RUDPPacket.PacketType.FRAGMENT, RUDPPacket.PacketType.BATCH:
+			pass
+		RUDPPacket.PacketType.ORDER_SKIP:
+			if packet.mode != RUDPPacket.DeliveryMode.RELIABLE_ORDERED:
+				return ERR_INVALID_DATA  # ErrInvalidPacket
+		RUDPPacket.PacketType.DISCONNECT:
+			handle_disconnect(packet)
+			return OK
+		RUDPPacket.PacketType.PING, RUDPPacket.PacketType.PONG:
+			handle_heartbeat(packet)
+			return OK
+		RUDPPacket.PacketType.ACK:
+			handle_ack(packet)
+			return OK
+		RUDPPacket.PacketType.PATH_CHALLENGE:
+			handle_path_challenge(packet)
+			return OK
+		RUDPPacket.PacketType.PATH_RESPONSE:
+			return OK
+		RUDPPacket.PacketType.MTU_PROBE:
+			handle_mtu_probe(packet)
+			return OK
+		RUDPPacket.PacketType.MTU_PROBE_ACK:
+			return OK
+		_:
+			return ERR_INVALID_DATA  # ErrInvalidPacket
+
+	if packet.channel >= CHANNELS:
+		return ERR_INVALID_DATA  # ErrInvalidChannel
+
+	# Refuse malformed batches before acking them
+	var messages = []
+	if packet.type == RUDPPacket.PacketType.BATCH:
+		messages = unbatch(packet)
+		if messages == null:
+			return ERR_INVALID_DATA  # ErrInvalidPacket
+
 	_last_received = Time.get_ticks_msec() / 1000.0
 
 	# Process acknowledgments (matching Go reliability.go:84)
 	process_acknowledgments(packet.ack, packet.ack_bits)
 
-	# Update remote sequence tracking (matching Go reliability.go:87)
-	if RUDPReliability.sequence_greater(packet.sequence, _remote_sequence):
-		update_ack_bits(packet.sequence)
-		_remote_sequence = packet.sequence
+	# Refuse fragments that cannot be reassembled before acking them, so they are retransmitted
+	if packet.type == RUDPPacket.PacketType.FRAGMENT:
+		var err = check_fragment(packet)
+		if err != OK:
+			return err
+
+	# Refuse reliable ordered packets too far ahead of their stream before acking them (matching Go checkOrder)
+	for message in messages + [packet]:
+		if message.is_ordered() and message.is_reliable():
+			var stream = get_stream(message.channel, message.mode)
+			if ((message.order_sequence - stream.next_recv) & 0xFFFF) >= ORDER_WINDOW \
+					and not RUDPReliability.sequence_greater(stream.next_recv, message.order_sequence):
+				return ERR_OUT_OF_MEMORY  # ErrOrderWindow
+
+	# Update remote sequence tracking (matching Go reliability.go)
+	var duplicate = record_received(packet.sequence)
+
+	# Reliable packets must be acked even if they are duplicates, since the
+	# duplicate means our previous ack was lost
+	if packet.is_reliable() and _ack_pending_since < 0:
+		_ack_pending_since = _last_received
+
+	if duplicate:
+		return OK
+
+	if packet.type == RUDPPacket.PacketType.FRAGMENT:
+		packet = reassemble(packet)
+		if packet == null:
+			return OK
+
+	if packet.type == RUDPPacket.PacketType.BATCH:
+		for message in messages:
+			handle_packet_delivery(message)
+		return OK
 
 	# Handle packet based on delivery mode (matching Go reliability.go:94)
 	handle_packet_delivery(packet)
 
 	return OK
 
+## unbatch unpacks the messages of a BATCH packet, or returns null if it is malformed (matching Go batch.go)
+## Sending batches is not supported, only receiving them from servers with BatchInterval set
+func unbatch(packet: RUDPPacket):
+	var messages = []
+	var offset = 0
+	while offset < packet.data.size():
+		if packet.data.size() - offset < BATCH_ENTRY_HEADER_SIZE:
+			return null
+		var size = packet.data.decode_u16(offset + 4)
+		if packet.data.size() - offset < BATCH_ENTRY_HEADER_SIZE + size:
+			return null
+		var message = RUDPPacket.new()
+		message.type = RUDPPacket.PacketType.DATA
+		message.client_id = packet.client_id
+		message.sequence = packet.sequence
+		message.mode = packet.data[offset]
+		message.channel = packet.data[offset + 1]
+		message.order_sequence = packet.data.decode_u16(offset + 2)
+		message.data = packet.data.slice(offset + BATCH_ENTRY_HEADER_SIZE, offset + BATCH_ENTRY_HEADER_SIZE + size)
+		if message.channel >= CHANNELS:
+			return null
+		messages.append(message)
+		offset += BATCH_ENTRY_HEADER_SIZE + size
+	return messages
+
+## check_fragment validates a FRAGMENT packet and makes sure there is room to reassemble it (matching Go fragment.go)
+func check_fragment(packet: RUDPPacket) -> int:
+	if packet.data.size() < FRAGMENT_HEADER_SIZE:
+		return ERR_INVALID_DATA  # ErrInvalidPacket
+	var id = packet.data.decode_u16(0)
+	var index = packet.data[2]
+	var count = packet.data[3]
+	if count == 0 or index >= count or packet.data.size() == FRAGMENT_HEADER_SIZE:
+		return ERR_INVALID_DATA  # ErrInvalidPacket
+	if count > MAX_FRAGMENTS:
+		return ERR_INVALID_PARAMETER  # ErrPacketTooLarge
+
+	expire_reassemblies()
+
+	var chunk_size = packet.data.size() - FRAGMENT_HEADER_SIZE
+	var need = _reassembly_memory(count, chunk_size)
+	if _reassemblies.has(id):
+		var r = _reassemblies[id]
+		if not _reassembly_matches(r, packet, count):
+			need -= _reassembly_memory(r.chunks.size(), r.bytes)  # The stale message is replaced
+		elif r.chunks[index] != null:
+			return OK
+		else:
+			need = chunk_size
+	if _reassembly_bytes + need > MAX_REASSEMBLY_BYTES:
+		return ERR_OUT_OF_MEMORY  # ErrReassemblyFull
+	return OK
+
+## reassemble stores a fragment and returns the complete message once all its fragments arrived, or null (matching Go fragment.go)
+func reassemble(packet: RUDPPacket) -> RUDPPacket:
+	var id = packet.data.decode_u16(0)
+	var index = packet.data[2]
+	var count = packet.data[3]
+	var chunk = packet.data.slice(FRAGMENT_HEADER_SIZE)
+
+	var r = _reassemblies.get(id)
+	if r == null or not _reassembly_matches(r, packet, count):
+		if r != null:
+			_drop_reassembly(id)
+		var chunks = []
+		chunks.resize(count)
+		r = {
+			"mode": packet.mode,
+			"channel": packet.channel,
+			"order_sequence": packet.order_sequence,
+			"chunks": chunks,
+			"received": 0,
+			"bytes": 0,
+			"started": Time.get_ticks_msec() / 1000.0
+		}
+		_reassemblies[id] = r
+		_reassembly_bytes += _reassembly_memory(count, 0)
+
+	if r.chunks[index] == null:
+		r.chunks[index] = chunk
+		r.received += 1
+		r.bytes += chunk.size()
+		_reassembly_bytes += chunk.size()
+
+	if r.received < r.chunks.size():
+		return null
+
+	_drop_reassembly(id)
+
+	var data = PackedByteArray()
+	for c in r.chunks:
+		data.append_array(c)
+
+	var message = RUDPPacket.new()
+	message.type = RUDPPacket.PacketType.DATA
+	message.client_id = packet.client_id
+	message.sequence = packet.sequence
+	message.ack = packet.ack
+	message.ack_bits = packet.ack_bits
+	message.mode = r.mode
+	message.channel = r.channel
+	message.order_sequence = r.order_sequence
+	message.timestamp = packet.timestamp
+	message.data = data
+	return message
+
+## _reassembly_matches reports whether a fragment belongs to the message being reassembled rather than an older one with a wrapped ID
+func _reassembly_matches(r: Dictionary, packet: RUDPPacket, count: int) -> bool:
+	return r.mode == packet.mode and r.channel == packet.channel and r.order_sequence == packet.order_sequence and r.chunks.size() == count
+
+## _reassembly_memory is the memory charged for an incomplete message against MAX_REASSEMBLY_BYTES (matching Go fragment.go)
+func _reassembly_memory(count: int, bytes: int) -> int:
+	return REASSEMBLY_OVERHEAD + count * CHUNK_OVERHEAD + bytes
+
+## expire_reassemblies drops messages whose fragments did not all arrive within FRAGMENT_TIMEOUT (matching Go fragment.go)
+## Dictionaries keep insertion order, so only the expired messages at the front are visited
+func expire_reassemblies() -> void:
+	var now = Time.get_ticks_msec() / 1000.0
+	var expired = []
+	for id in _reassemblies:
+		if now - _reassemblies[id].started <= FRAGMENT_TIMEOUT:
+			break
+		expired.append(id)
+	for id in expired:
+		_drop_reassembly(id)
+
+## _drop_reassembly forgets an incomplete message and releases its memory (matching Go fragment.go)
+func _drop_reassembly(id: int) -> void:
+	var r = _reassemblies[id]
+	_reassemblies.erase(id)
+	_reassembly_bytes -= _reassembly_memory(r.chunks.size(), r.bytes)
+
+## check_heartbeat sends a PING when idle and times out a silent peer (matching Go heartbeat.go)
+func check_heartbeat() -> void:
+	var now = Time.get_ticks_msec() / 1000.0
+
+	if now - _last_received >= INACTIVITY_TIMEOUT:
+		shutdown(DisconnectReason.TIMEOUT, PackedByteArray())
+		return
+
+	if now - _last_sent >= HEARTBEAT_INTERVAL:
+		var data = PackedByteArray()
+		data.resize(8)
+		data.encode_s64(0, Time.get_ticks_usec() * 1000)
+		queue_control(RUDPPacket.PacketType.PING, data)
+
+## handle_heartbeat processes a PING or PONG, answering PINGs with a PONG (matching Go heartbeat.go)
+func handle_heartbeat(packet: RUDPPacket) -> void:
+	_last_received = Time.get_ticks_msec() / 1000.0
+	process_acknowledgments(packet.ack, packet.ack_bits)
+
+	if packet.type == RUDPPacket.PacketType.PING:
+		queue_control(RUDPPacket.PacketType.PONG, packet.data)
+
+## handle_mtu_probe answers a path MTU probe of the server (matching Go mtu.go)
+## Probing is not supported, packets are never larger than MAX_PACKET_SIZE
+func handle_mtu_probe(packet: RUDPPacket) -> void:
+	_last_received = Time.get_ticks_msec() / 1000.0
+	process_acknowledgments(packet.ack, packet.ack_bits)
+	if packet.data.size() >= MTU_PROBE_ID_SIZE:
+		queue_control(RUDPPacket.PacketType.MTU_PROBE_ACK, packet.data.slice(0, MTU_PROBE_ID_SIZE))
+
+## handle_path_challenge answers a PATH_CHALLENGE the server sent after our address changed (matching Go migration.go)
+func handle_path_challenge(packet: RUDPPacket) -> void:
+	if path_key.is_empty():
+		return
+	queue_control(RUDPPacket.PacketType.PATH_RESPONSE, hmac_sha256(path_key, packet.data).slice(0, PATH_PROOF_SIZE))
+
+## hmac_sha256 computes an HMAC-SHA256 (matching Go crypto/hmac)
+static func hmac_sha256(key: PackedByteArray, data: PackedByteArray) -> PackedByteArray:
+	var ctx = HMACContext.new()
+	ctx.start(HashingContext.HASH_SHA256, key)
+	ctx.update(data)
+	return ctx.finish()
+
+## queue_control queues an unsequenced control packet carrying the current acks (matching Go heartbeat.go)
+func queue_control(packet_type: int, data: PackedByteArray) -> void:
+	if _closed:
+		return
+
+	var packet = RUDPPacket.new()
+	packet.type = packet_type
+	packet.client_id = _client_id
+	packet.ack = _remote_sequence
+	packet.ack_bits = _ack_bits
+	packet.data = data
+	packet.timestamp = Time.get_ticks_msec()
+	_ack_pending_since = -1.0
+
+	if _outbound.size() < CHANNEL_BUFFER_SIZE:
+		_outbound.append(packet)
+
+## check_ack sends a standalone ACK if no outgoing packet carried the current acks within ACK_DELAY (matching Go reliability.go flushAck)
+func check_ack() -> void:
+	if _ack_pending_since < 0:
+		return
+
+	if Time.get_ticks_msec() / 1000.0 - _ack_pending_since >= ACK_DELAY:
+		queue_control(RUDPPacket.PacketType.ACK, PackedByteArray())
+
+## handle_ack processes a standalone ACK (matching Go reliability.go)
+func handle_ack(packet: RUDPPacket) -> void:
+	_last_received = Time.get_ticks_msec() / 1000.0
+	process_acknowledgments(packet.ack, packet.ack_bits)
+
 ## process_acknowledgments removes acknowledged packets from pending list (matching Go reliability.go:95)
 func process_acknowledgments(ack: int, ack_bits_received: int) -> void:
 	# Acknowledge the explicit ack
@@ -168,13 +556,37 @@ func process_acknowledgments(ack: int, ack_bits_received: int) -> void:
 			var seq = (ack - (i + 1)) & 0xFFFF
 			_pending_acks.erase(seq)
 
-## update_ack_bits updates the acknowledgment bitfield (matching Go reliability.go:109)
+## record_received marks a sequence as received and reports whether it was a duplicate (matching Go reliability.go)
+## Sequences too old to fit in the window are reported as duplicates
+func record_received(seq: int) -> bool:
+	if not _received_any:
+		_received_any = true
+		_remote_sequence = seq
+		_ack_bits = 0
+		return false
+
+	if RUDPReliability.sequence_greater(seq, _remote_sequence):
+		update_ack_bits(seq)
+		_remote_sequence = seq
+		return false
+
+	var diff = (_remote_sequence - seq) & 0xFFFF
+	if diff == 0 or diff > 32:
+		return true
+
+	var bit = 1 << (diff - 1)
+	if (_ack_bits & bit) != 0:
+		return true
+	_ack_bits |= bit
+	return false
+
+## update_ack_bits shifts the acknowledgment bitfield for a new most recent sequence (matching Go reliability.go)
 func update_ack_bits(new_seq: int) -> void:
-	var diff = new_seq - _remote_sequence
+	var diff = (new_seq - _remote_sequence) & 0xFFFF
 	if diff > 32:
 		_ack_bits = 0
 	else:
-		_ack_bits = (_ack_bits << diff) | 1
+		_ack_bits = ((_ack_bits << diff) | (1 << (diff - 1))) & 0xFFFFFFFF
 
 ## handle_packet_delivery processes packet based on delivery guarantees (matching Go reliability.go:119)
 func handle_packet_delivery(packet: RUDPPacket) -> void:
@@ -185,18 +597,69 @@ func handle_packet_delivery(packet: RUDPPacket) -> void:
 
 ## handle_ordered_delivery ensures packets are delivered in sequence (matching Go reliability.go:128)
 func handle_ordered_delivery(packet: RUDPPacket) -> void:
-	_ordered_buffer[packet.sequence] = packet
-
-	# Deliver consecutive packets (matching Go reliability.go:134)
-	while true:
-		var next_seq = (_remote_sequence + 1) & 0xFFFF
-		if _ordered_buffer.has(next_seq):
-			var p = _ordered_buffer[next_seq]
-			_ordered_buffer.erase(next_seq)
-			_remote_sequence = next_seq
+	var stream = get_stream(packet.channel, packet.mode)
+
+	# Packets older than the next expected one were already delivered (matching Go stream.go push)
+	if RUDPReliability.sequence_greater(stream.next_recv, packet.order_sequence):
+		return
+
+	# Sequenced packets are never buffered, anything newer is delivered skipping what was lost
+	if packet.is_sequenced():
+		stream.next_recv = (packet.order_sequence + 1) & 0xFFFF
+		deliver_packet(packet)
+		return
+
+	var now = Time.get_ticks_msec() / 1000.0
+	var start = stream.next_recv
+	var ahead = (packet.order_sequence - stream.next_recv) & 0xFFFF
+	if ahead >= ORDER_WINDOW:
+		# Reliable packets this far ahead were refused unacked, unreliable ones make room
+		if packet.is_reliable():
+			return
+		_skip_to(stream, (packet.order_sequence - ORDER_WINDOW + 1) & 0xFFFF)
+
+	var held = not stream.buffer.is_empty() and now - stream.held_since >= ORDER_HOLD
+	if not stream.buffer.has(packet.order_sequence):
+		stream.buffer[packet.order_sequence] = packet
+	if held and not packet.is_reliable():
+		# Give up on the missing packet that held up the stream for ORDER_HOLD
+		var oldest = packet.order_sequence
+		for seq in stream.buffer.keys():
+			if RUDPReliability.sequence_greater(oldest, seq):
+				oldest = seq
+		_skip_to(stream, oldest)
+
+	# Deliver consecutive packets
+	while stream.buffer.has(stream.next_recv):
+		var p = stream.buffer[stream.next_recv]
+		stream.buffer.erase(stream.next_recv)
+		stream.next_recv = (stream.next_recv + 1) & 0xFFFF
+		if p.type != RUDPPacket.PacketType.ORDER_SKIP:
 			deliver_packet(p)
-		else:
-			break
+
+	# The hold is timed from when the packet now missing first held up another one
+	if stream.buffer.is_empty():
+		stream.held_since = 0.0
+	elif stream.held_since == 0.0 or stream.next_recv != start:
+		stream.held_since = now
+
+## _skip_to gives up on the missing packets of a stream before an order sequence, delivering the buffered ones in between (matching Go stream.go)
+func _skip_to(stream: Dictionary, seq: int) -> void:
+	while RUDPReliability.sequence_greater(seq, stream.next_recv):
+		if stream.buffer.has(stream.next_recv):
+			var p = stream.buffer[stream.next_recv]
+			stream.buffer.erase(stream.next_recv)
+			if p.type != RUDPPacket.PacketType.ORDER_SKIP:
+				deliver_packet(p)
+		stream.next_recv = (stream.next_recv + 1) & 0xFFFF
+
+## get_stream returns the ordered stream of a delivery mode on a channel, creating it on first use (matching Go stream.go)
+## Order sequences are independent of the ack sequence so other modes or channels never leave gaps in the stream
+func get_stream(channel: int, mode: int) -> Dictionary:
+	var key = channel * 256 + mode
+	if not _streams.has(key):
+		_streams[key] = {"next_send": 0, "next_recv": 0, "buffer": {}, "held_since": 0.0}
+	return _streams[key]
 
 ## deliver_packet sends packet to the application (matching Go reliability.go:147)
 func deliver_packet(packet: RUDPPacket) -> void:
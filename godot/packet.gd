@@ -8,29 +8,47 @@ enum PacketType {
 	DATA = 0,
 	CONNECT = 1,
 	CONNECT_ACK = 2,
-	DISCONNECT = 3
+	DISCONNECT = 3,
+	PING = 4,  # Keepalive sent when a connection is idle, carries acks
+	PONG = 5,  # Reply to PING echoing its payload, carries acks
+	ACK = 6,   # Standalone acks, sent when no other packet carried them within ACK_DELAY
+	FRAGMENT = 7,  # Part of a message too large for one packet, reassembled before delivery
+	CONNECT_CHALLENGE = 8,  # Reply to CONNECT carrying a cookie the client must echo
+	CONNECT_RESPONSE = 9,   # Echo of the cookie proving the client owns its address
+	CONNECT_REJECT = 10,    # Reply to CONNECT_RESPONSE refusing the connection, carries a RejectReason
+	PATH_CHALLENGE = 11,    # Sent to a client's new address before the connection moves there
+	PATH_RESPONSE = 12,     # Answer to PATH_CHALLENGE proving the client holds the path key
+	BATCH = 13,             # Several small messages packed into one datagram, unpacked before delivery
+	MTU_PROBE = 14,         # Padded to the datagram size being tried by path MTU discovery
+	MTU_PROBE_ACK = 15,     # Answer to MTU_PROBE echoing its ID
+	ORDER_SKIP = 16         # Takes the place of a RELIABLE_ORDERED message the sender gave up on
 }
 
 # DeliveryMode defines how packets should be delivered (matching Go)
 enum DeliveryMode {
-	UNRELIABLE = 0,         # Fire and forget, no guarantees
-	UNRELIABLE_ORDERED = 1, # Ordered delivery, but may drop old packets
-	RELIABLE = 2,           # Guaranteed delivery, may arrive out of order
-	RELIABLE_ORDERED = 3    # Guaranteed delivery in sequence order
+	UNRELIABLE = 0,           # Fire and forget, no guarantees
+	UNRELIABLE_ORDERED = 1,   # Ordered delivery, but may drop old packets
+	RELIABLE = 2,             # Guaranteed delivery, may arrive out of order
+	RELIABLE_ORDERED = 3,     # Guaranteed delivery in sequence order
+	UNRELIABLE_SEQUENCED = 4  # Only the newest packet matters, older ones arriving late are dropped
 }
 
 # Constants (matching Go packet.go)
 const MAX_PACKET_SIZE = 1400  # bytes
-const HEADER_SIZE = 16        # Type(1) + ClientID(4) + Seq(2) + Ack(2) + AckBits(4) + Mode(1) + DataSize(2)
+const PROTOCOL_MAGIC = 0x5552  # "RU" on the wire
+const PROTOCOL_VERSION = 1
+const HEADER_SIZE = 22        # Magic(2) + Version(1) + Type(1) + ClientID(4) + Seq(2) + Ack(2) + AckBits(4) + Mode(1) + Channel(1) + OrderSeq(2) + DataSize(2)
 
 # Packet represents a network packet with metadata (matching Go struct)
 var type: int = PacketType.DATA  # PacketType (byte)
 var client_id: int = 0        # uint32 - Unique client identifier
 var id: int = 0               # uint16 (not used in wire protocol)
-var sequence: int = 0         # uint16
+var sequence: int = 0         # uint16 - Ack sequence, shared by all packets of a connection
 var ack: int = 0              # uint16
 var ack_bits: int = 0         # uint32
 var mode: int = 0             # DeliveryMode (byte)
+var channel: int = 0          # uint8 - Logical channel the packet was sent on
+var order_sequence: int = 0   # uint16 - Position within the stream of its channel and ordered delivery mode
 var timestamp: int = 0        # int64 (not used in wire protocol)
 var data: PackedByteArray = PackedByteArray()
 var attempts: int = 0         # For retransmission tracking
@@ -43,13 +61,17 @@ func marshal() -> PackedByteArray:
 	buf.resize(HEADER_SIZE + data.size())
 
 	# Write header (Little Endian) - matching Go binary.LittleEndian
-	buf[0] = type                           # buf[0] Type
-	buf.encode_u32(1, client_id)            # buf[1:5] ClientID
-	buf.encode_u16(5, sequence & 0xFFFF)    # buf[5:7] Sequence
-	buf.encode_u16(7, ack & 0xFFFF)         # buf[7:9] Ack
-	buf.encode_u32(9, ack_bits)             # buf[9:13] AckBits
-	buf[13] = mode                          # buf[13] Mode
-	buf.encode_u16(14, data.size())         # buf[14:16] DataSize
+	buf.encode_u16(0, PROTOCOL_MAGIC)       # buf[0:2] Magic
+	buf[2] = PROTOCOL_VERSION               # buf[2] Version
+	buf[3] = type                           # buf[3] Type
+	buf.encode_u32(4, client_id)            # buf[4:8] ClientID
+	buf.encode_u16(8, sequence & 0xFFFF)    # buf[8:10] Sequence
+	buf.encode_u16(10, ack & 0xFFFF)        # buf[10:12] Ack
+	buf.encode_u32(12, ack_bits)            # buf[12:16] AckBits
+	buf[16] = mode                          # buf[16] Mode
+	buf[17] = channel                       # buf[17] Channel
+	buf.encode_u16(18, order_sequence & 0xFFFF)  # buf[18:20] OrderSequence
+	buf.encode_u16(20, data.size())         # buf[20:22] DataSize
 
 	# Copy payload data - matching Go copy(buf[HeaderSize:], p.Data)
 	for i in range(data.size()):
@@ -58,18 +80,24 @@ func marshal() -> PackedByteArray:
 	return buf
 
 ## Unmarshal deserializes a packet from network data (matching Go)
+## Returns ERR_INVALID_DATA for another protocol version, with only type and client_id decoded
 func unmarshal(raw_data: PackedByteArray) -> int:
-	if raw_data.size() < HEADER_SIZE:
+	if raw_data.size() < HEADER_SIZE or raw_data.decode_u16(0) != PROTOCOL_MAGIC:
 		return ERR_INVALID_PARAMETER  # ErrInvalidPacket
 
 	# Read header (Little Endian) - matching Go binary.LittleEndian
-	type = raw_data[0]                      # data[0] Type
-	client_id = raw_data.decode_u32(1)      # data[1:5] ClientID
-	sequence = raw_data.decode_u16(5)       # data[5:7] Sequence
-	ack = raw_data.decode_u16(7)            # data[7:9] Ack
-	ack_bits = raw_data.decode_u32(9)       # data[9:13] AckBits
-	mode = raw_data[13]                     # data[13] Mode
-	var data_size = raw_data.decode_u16(14) # data[14:16] DataSize
+	type = raw_data[3]                      # data[3] Type
+	client_id = raw_data.decode_u32(4)      # data[4:8] ClientID
+	if raw_data[2] != PROTOCOL_VERSION:     # data[2] Version
+		return ERR_INVALID_DATA  # ErrVersionMismatch
+
+	sequence = raw_data.decode_u16(8)       # data[8:10] Sequence
+	ack = raw_data.decode_u16(10)           # data[10:12] Ack
+	ack_bits = raw_data.decode_u32(12)      # data[12:16] AckBits
+	mode = raw_data[16]                     # data[16] Mode
+	channel = raw_data[17]                  # data[17] Channel
+	order_sequence = raw_data.decode_u16(18)  # data[18:20] OrderSequence
+	var data_size = raw_data.decode_u16(20) # data[20:22] DataSize
 
 	if raw_data.size() < HEADER_SIZE + data_size:
 		return ERR_INVALID_PARAMETER  # ErrInvalidPacket
@@ -85,4 +113,8 @@ func is_reliable() -> bool:
 
 ## IsOrdered returns true if this packet must be delivered in order (matching Go)
 func is_ordered() -> bool:
-	return mode == DeliveryMode.UNRELIABLE_ORDERED or mode == DeliveryMode.RELIABLE_ORDERED
+	return mode == DeliveryMode.UNRELIABLE_ORDERED or mode == DeliveryMode.RELIABLE_ORDERED or mode == DeliveryMode.UNRELIABLE_SEQUENCED
+
+## IsSequenced returns true if this packet is dropped rather than buffered when it arrives after a newer one (matching Go)
+func is_sequenced() -> bool:
+	return mode == DeliveryMode.UNRELIABLE_SEQUENCED
@@ -0,0 +1,195 @@
+package rudp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// Connect cookies let the server verify that a client owns its source address
+// without keeping any state for it. The server answers CONNECT with a
+// CONNECT_CHALLENGE carrying a cookie bound to the client's address, ClientID
+// and the current time; only a CONNECT_RESPONSE echoing a valid cookie creates
+// a Connection.
+const (
+	cookieMACSize        = 16                     // Truncated HMAC-SHA256
+	cookieSize           = 8 + cookieMACSize      // IssuedAt(8) + MAC(16)
+	cookieLifetime       = 10 * time.Second       // How long a cookie is accepted after it was issued
+	cookieSecretSize     = 32                     // Size of the server's cookie key
+	connectPadding       = cookieSize             // CONNECT is padded so the challenge never exceeds the request
+	handshakeInterval    = 250 * time.Millisecond // How often the client resends an unanswered handshake packet
+	handshakePoll        = 100 * time.Millisecond // Read deadline while waiting for handshake replies
+	handshakeReplyBuffer = 8                      // Handshake packets a reconnecting client queues
+	connectionTokenSize  = 16                     // Random token sent in CONNECT_ACK
+)
+
+// Labels separating the keys derived from the connection token
+const (
+	resumeKeyLabel = "rudp resume"
+	pathKeyLabel   = "rudp path"
+)
+
+// newCookieSecret generates a random key for signing connect cookies
+func newCookieSecret() []byte {
+	secret := make([]byte, cookieSecretSize)
+	rand.Read(secret)
+	return secret
+}
+
+// cookieMAC computes the MAC binding a cookie to the client's address, ClientID and issue time
+func cookieMAC(secret []byte, addr *net.UDPAddr, clientID uint32, issued int64) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(addr.IP.To16())
+
+	var buf [14]byte
+	binary.LittleEndian.PutUint16(buf[0:2], uint16(addr.Port))
+	binary.LittleEndian.PutUint32(buf[2:6], clientID)
+	binary.LittleEndian.PutUint64(buf[6:14], uint64(issued))
+	mac.Write(buf[:])
+
+	return mac.Sum(nil)[:cookieMACSize]
+}
+
+// newCookie issues a cookie for a client at the given address
+func newCookie(secret []byte, addr *net.UDPAddr, clientID uint32, now time.Time) []byte {
+	issued := now.UnixNano()
+	cookie := make([]byte, 8, cookieSize)
+	binary.LittleEndian.PutUint64(cookie, uint64(issued))
+	return append(cookie, cookieMAC(secret, addr, clientID, issued)...)
+}
+
+// verifyCookie checks that a cookie was issued by this server to the client
+// at the given address and has not expired
+func verifyCookie(secret []byte, cookie []byte, addr *net.UDPAddr, clientID uint32, now time.Time) error {
+	if len(cookie) < cookieSize {
+		return ErrInvalidCookie
+	}
+
+	issued := int64(binary.LittleEndian.Uint64(cookie[0:8]))
+	age := now.Sub(time.Unix(0, issued))
+	if age < 0 || age > cookieLifetime {
+		return ErrInvalidCookie
+	}
+
+	if !hmac.Equal(cookie[8:cookieSize], cookieMAC(secret, addr, clientID, issued)) {
+		return ErrInvalidCookie
+	}
+	return nil
+}
+
+// connectResponse is the payload of CONNECT_RESPONSE: the cookie followed by
+// length-prefixed optional fields. Fields missing from the end are empty.
+type connectResponse struct {
+	cookie    []byte
+	publicKey []byte // Client's key exchange key, in secure mode
+	token     []byte // Sealed connect token, if the client has one
+	proof     []byte // MAC proving the client holds the token's session key
+	payload   []byte // Application data for OnConnectRequest and Connection.HandshakePayload
+	resume    []byte // Proof of the resume key, when resuming an existing session
+}
+
+// marshal serializes the response
+func (r *connectResponse) marshal() []byte {
+	buf := append([]byte{}, r.cookie...)
+	return appendFields(buf, r.publicKey, r.token, r.proof, r.payload, r.resume)
+}
+
+// parseConnectResponse deserializes a response
+func parseConnectResponse(data []byte) (*connectResponse, error) {
+	if len(data) < cookieSize {
+		return nil, ErrInvalidCookie
+	}
+	r := &connectResponse{cookie: data[:cookieSize]}
+	if err := parseFields(data[cookieSize:], &r.publicKey, &r.token, &r.proof, &r.payload, &r.resume); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// connectAck is the payload of CONNECT_ACK, made of length-prefixed optional fields
+type connectAck struct {
+	publicKey []byte // Server's key exchange key, in secure mode
+	payload   []byte // Application data returned by OnConnectRequest
+	token     []byte // Connection token, from which the keys for resuming and migrating the connection are derived
+}
+
+// marshal serializes the ack
+func (a *connectAck) marshal() []byte {
+	return appendFields(nil, a.publicKey, a.payload, a.token)
+}
+
+// parseConnectAck deserializes an ack
+func parseConnectAck(data []byte) (*connectAck, error) {
+	a := &connectAck{}
+	if err := parseFields(data, &a.publicKey, &a.payload, &a.token); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// appendFields serializes fields, each prefixed with its length
+func appendFields(buf []byte, fields ...[]byte) []byte {
+	for _, field := range fields {
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(field)))
+		buf = append(buf, field...)
+	}
+	return buf
+}
+
+// parseFields deserializes length-prefixed fields. Fields missing from the end are left empty.
+func parseFields(data []byte, fields ...*[]byte) error {
+	for _, field := range fields {
+		if len(data) == 0 {
+			break
+		}
+		if len(data) < 2 {
+			return ErrInvalidPacket
+		}
+		size := int(binary.LittleEndian.Uint16(data))
+		if len(data) < 2+size {
+			return ErrInvalidPacket
+		}
+		*field = data[2 : 2+size]
+		data = data[2+size:]
+	}
+	return nil
+}
+
+// tokenProof computes the MAC with which a client proves it holds the session key
+// of its connect token, so a token seen on the wire cannot be used by anyone else
+func tokenProof(sessionKey []byte, r *connectResponse) []byte {
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write(r.cookie)
+	mac.Write(r.publicKey)
+	mac.Write(r.token)
+	mac.Write(r.payload)
+	return mac.Sum(nil)[:cookieMACSize]
+}
+
+// newConnectionToken generates the token a server sends with CONNECT_ACK
+func newConnectionToken() []byte {
+	token := make([]byte, connectionTokenSize)
+	rand.Read(token)
+	return token
+}
+
+// connectionKey derives a key for the given purpose from the connection token. On secure
+// connections the key also depends on the session keys, so the token seen on the wire is
+// not enough. Without a token there is no key.
+func connectionKey(sess *session, token []byte, label string) []byte {
+	if len(token) == 0 {
+		return nil
+	}
+	key := token
+	if sess != nil {
+		mac := hmac.New(sha256.New, sess.secret)
+		mac.Write(token)
+		key = mac.Sum(nil)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
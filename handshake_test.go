@@ -0,0 +1,238 @@
+package rudp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestVerifyCookie(t *testing.T) {
+	secret := newCookieSecret()
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 9000}
+	now := time.Now()
+	cookie := newCookie(secret, addr, 7, now)
+
+	tampered := append([]byte{}, cookie...)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name     string
+		secret   []byte
+		cookie   []byte
+		addr     *net.UDPAddr
+		clientID uint32
+		at       time.Time
+		wantErr  error
+	}{
+		{"valid", secret, cookie, addr, 7, now, nil},
+		{"valid until its lifetime ends", secret, cookie, addr, 7, now.Add(cookieLifetime), nil},
+		{"expired", secret, cookie, addr, 7, now.Add(cookieLifetime + time.Nanosecond), ErrInvalidCookie},
+		{"issued in the future", secret, cookie, addr, 7, now.Add(-time.Second), ErrInvalidCookie},
+		{"wrong IP", secret, cookie, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 9000}, 7, now, ErrInvalidCookie},
+		{"wrong port", secret, cookie, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 9001}, 7, now, ErrInvalidCookie},
+		{"wrong client", secret, cookie, addr, 8, now, ErrInvalidCookie},
+		{"other server", newCookieSecret(), cookie, addr, 7, now, ErrInvalidCookie},
+		{"tampered", secret, tampered, addr, 7, now, ErrInvalidCookie},
+		{"truncated", secret, cookie[:cookieSize-1], addr, 7, now, ErrInvalidCookie},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyCookie(tt.secret, tt.cookie, tt.addr, tt.clientID, tt.at); !errors.Is(err, tt.wantErr) {
+				t.Errorf("verifyCookie() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConnectPadding(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv6loopback, Port: 9000}
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"default", DefaultConfig()},
+		{"checksum", Config{Checksum: true}},
+		{"secure", Config{Secure: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config.withDefaults()
+			client := newClient(config)
+			request := config.marshal(client.connectRequest())
+
+			// The challenge, the only reply to a CONNECT before the client proves its
+			// address, must never be larger, so spoofed CONNECTs cannot amplify traffic
+			challenge := config.marshal(&Packet{
+				Type:     CONNECT_CHALLENGE,
+				ClientID: client.clientID,
+				Data:     newCookie(newCookieSecret(), addr, client.clientID, time.Now()),
+			})
+			if len(challenge) > len(request) {
+				t.Errorf("CONNECT_CHALLENGE of %d bytes is larger than the %d byte CONNECT", len(challenge), len(request))
+			}
+		})
+	}
+}
+
+func TestHandleConnectUnpadded(t *testing.T) {
+	s := newServer(DefaultConfig())
+	packet := &Packet{Type: CONNECT, ClientID: 7, Data: make([]byte, connectPadding-1)}
+	if err := s.handleConnect(packet, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 9000}); !errors.Is(err, ErrInvalidPacket) {
+		t.Errorf("handleConnect() error = %v, want %v", err, ErrInvalidPacket)
+	}
+}
+
+func TestConnectResponseRoundTrip(t *testing.T) {
+	cookie := make([]byte, cookieSize)
+	tests := []struct {
+		name     string
+		response connectResponse
+	}{
+		{"cookie only", connectResponse{cookie: cookie}},
+		{"payload", connectResponse{cookie: cookie, payload: []byte("player one")}},
+		{"all fields", connectResponse{
+			cookie:    cookie,
+			publicKey: []byte("public key"),
+			token:     []byte("token"),
+			proof:     []byte("proof"),
+			payload:   []byte("payload"),
+			resume:    []byte("resume"),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseConnectResponse(tt.response.marshal())
+			if err != nil {
+				t.Fatalf("parseConnectResponse() error = %v", err)
+			}
+			fields := []struct {
+				name      string
+				got, want []byte
+			}{
+				{"cookie", got.cookie, tt.response.cookie},
+				{"publicKey", got.publicKey, tt.response.publicKey},
+				{"token", got.token, tt.response.token},
+				{"proof", got.proof, tt.response.proof},
+				{"payload", got.payload, tt.response.payload},
+				{"resume", got.resume, tt.response.resume},
+			}
+			for _, f := range fields {
+				if !bytes.Equal(f.got, f.want) {
+					t.Errorf("%s = %q, want %q", f.name, f.got, f.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFieldsRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated length", []byte{1}},
+		{"length past end", []byte{5, 0, 'a', 'b'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var field []byte
+			if err := parseFields(tt.data, &field); !errors.Is(err, ErrInvalidPacket) {
+				t.Errorf("parseFields() error = %v, want %v", err, ErrInvalidPacket)
+			}
+		})
+	}
+}
+
+func TestHandshakePayload(t *testing.T) {
+	s := NewServer()
+	requested := make(chan []byte, 1)
+	s.OnConnectRequest = func(_ *net.UDPAddr, _ uint32, payload []byte) ([]byte, RejectReason) {
+		requested <- append([]byte{}, payload...)
+		return []byte("welcome " + string(payload)), RejectNone
+	}
+	connected := make(chan *Connection, 1)
+	s.OnConnect = func(conn *Connection) { connected <- conn }
+	addr := listenTestServer(t, s)
+
+	client := NewClient()
+	reply, err := client.ConnectWithPayload(addr, []byte("alice"))
+	if err != nil {
+		t.Fatalf("ConnectWithPayload() error = %v", err)
+	}
+	defer client.Close()
+
+	if payload := <-requested; string(payload) != "alice" {
+		t.Errorf("OnConnectRequest got %q, want %q", payload, "alice")
+	}
+	if string(reply) != "welcome alice" {
+		t.Errorf("ConnectWithPayload() = %q, want %q", reply, "welcome alice")
+	}
+	if conn := <-connected; string(conn.HandshakePayload()) != "alice" {
+		t.Errorf("server HandshakePayload() = %q, want %q", conn.HandshakePayload(), "alice")
+	}
+}
+
+func TestAcceptFailsBeforeHook(t *testing.T) {
+	s, err := NewServerWithConfig(Config{Secure: true})
+	if err != nil {
+		t.Fatalf("NewServerWithConfig() error = %v", err)
+	}
+	asked := false
+	s.OnConnectRequest = func(*net.UDPAddr, uint32, []byte) ([]byte, RejectReason) {
+		asked = true
+		return nil, RejectNone
+	}
+
+	// Without the client's key the exchange fails before the application is asked
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	response := &connectResponse{cookie: make([]byte, cookieSize)}
+	packet := &Packet{Type: CONNECT_RESPONSE, ClientID: 1, Data: response.marshal()}
+	if conn, err := s.accept(packet, response, addr); conn != nil || err == nil {
+		t.Errorf("accept() = %v, %v, want the key exchange to fail", conn, err)
+	}
+	if asked {
+		t.Error("OnConnectRequest called for a client that could not be accepted")
+	}
+}
+
+func TestConnectAborted(t *testing.T) {
+	s := NewServer()
+	s.OnConnectRequest = func(*net.UDPAddr, uint32, []byte) ([]byte, RejectReason) {
+		return make([]byte, DefaultMaxPacketSize), RejectNone
+	}
+	aborted := make(chan uint32, 1)
+	s.OnConnectAborted = func(_ *net.UDPAddr, clientID uint32) {
+		select {
+		case aborted <- clientID:
+		default: // Resent responses are turned away again
+		}
+	}
+	addr := listenTestServer(t, s)
+
+	// A reply too large for a packet turns the accepted client away, and the application hears of it
+	client := NewClient()
+	var rejected *RejectedError
+	if _, err := client.ConnectWithPayload(addr, nil); !errors.As(err, &rejected) || rejected.Reason != RejectDenied {
+		t.Fatalf("ConnectWithPayload() error = %v, want %v", err, RejectDenied)
+	}
+	select {
+	case clientID := <-aborted:
+		if clientID != client.clientID {
+			t.Errorf("OnConnectAborted got client %d, want %d", clientID, client.clientID)
+		}
+	case <-time.After(time.Second):
+		t.Error("OnConnectAborted not called")
+	}
+}
+
+func TestConnectWithPayloadTooLarge(t *testing.T) {
+	client := NewClient()
+	if _, err := client.ConnectWithPayload("127.0.0.1:9", make([]byte, DefaultMaxPacketSize)); !errors.Is(err, ErrPacketTooLarge) {
+		t.Errorf("ConnectWithPayload() error = %v, want %v", err, ErrPacketTooLarge)
+	}
+}
@@ -0,0 +1,93 @@
+package rudp
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// processHeartbeats keeps idle connections alive and closes connections whose peer went silent
+func (c *Connection) processHeartbeats() {
+	interval := c.config.HeartbeatInterval / 4
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.checkHeartbeat()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// checkHeartbeat sends a PING if nothing has been sent for HeartbeatInterval
+// and times the connection out if nothing has been received for InactivityTimeout.
+// Resumable connections are suspended instead, and only closed once their grace period ends.
+func (c *Connection) checkHeartbeat() {
+	c.mu.RLock()
+	idleSend := time.Since(c.lastSent)
+	idleReceive := time.Since(c.lastReceived)
+	c.mu.RUnlock()
+
+	if c.expired() {
+		c.shutdown(DisconnectTimeout, nil)
+		return
+	}
+	if idleReceive >= c.config.InactivityTimeout {
+		c.suspend()
+	}
+
+	if idleSend >= c.config.HeartbeatInterval {
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, uint64(time.Now().UnixNano()))
+		c.queueControl(PING, data)
+	}
+}
+
+// handleHeartbeat processes a PING or PONG, answering PINGs with a PONG that echoes their payload.
+// PONGs echo the send time of our PING, which gives a round-trip sample on idle connections.
+func (c *Connection) handleHeartbeat(packet *Packet) {
+	c.mu.Lock()
+	c.lastReceived = time.Now()
+	c.processAcknowledgments(packet.Ack, packet.AckBits)
+	if packet.Type == PONG && len(packet.Data) == 8 {
+		sent := time.Unix(0, int64(binary.LittleEndian.Uint64(packet.Data)))
+		c.rtt.update(c.lastReceived.Sub(sent))
+	}
+	c.mu.Unlock()
+
+	if packet.Type == PING {
+		c.queueControl(PONG, packet.Data)
+	}
+}
+
+// queueControl queues an unsequenced control packet carrying the current acks.
+// Control packets are dropped rather than blocking when the outbound buffer is full.
+func (c *Connection) queueControl(packetType PacketType, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queueControlLocked(packetType, data)
+}
+
+// queueControlLocked is queueControl for callers that hold the lock
+func (c *Connection) queueControlLocked(packetType PacketType, data []byte) {
+	if c.closed {
+		return
+	}
+	packet := &Packet{
+		Type:      packetType,
+		ClientID:  c.clientID,
+		Ack:       c.remoteSequence,
+		AckBits:   c.ackBits,
+		Data:      data,
+		Timestamp: time.Now().UnixNano(),
+	}
+	c.ackPending = false
+
+	// If the buffer is full, the next heartbeat will try again
+	c.enqueue(packet)
+}
@@ -0,0 +1,98 @@
+package rudp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// drainOutbound returns the packets waiting for the socket
+func drainOutbound(c *Connection) []*Packet {
+	var packets []*Packet
+	for len(c.outbound) > 0 {
+		packets = append(packets, <-c.outbound)
+	}
+	return packets
+}
+
+func TestCheckHeartbeat(t *testing.T) {
+	tests := []struct {
+		name          string
+		resumable     bool
+		idleSend      time.Duration
+		idleReceive   time.Duration
+		wantPing      bool
+		wantSuspended bool
+		wantClosed    bool
+	}{
+		{"busy", false, 0, 0, false, false, false},
+		{"idle sender", false, 2 * DefaultHeartbeatInterval, 0, true, false, false},
+		{"silent peer", false, 0, 2 * DefaultInactivityTimeout, false, false, true},
+		{"silent peer of resumable connection", true, 0, 2 * DefaultInactivityTimeout, false, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			if tt.resumable {
+				config.ResumeGracePeriod = time.Minute
+			}
+			c := newTestConnection(config)
+			if tt.resumable {
+				c.resumeKey = make([]byte, 32)
+			}
+			now := time.Now()
+			c.lastSent = now.Add(-tt.idleSend)
+			c.lastReceived = now.Add(-tt.idleReceive)
+
+			c.checkHeartbeat()
+
+			pinged := false
+			for _, packet := range drainOutbound(c) {
+				if packet.Type == PING && len(packet.Data) == 8 {
+					pinged = true
+				}
+			}
+			if pinged != tt.wantPing {
+				t.Errorf("sent PING = %v, want %v", pinged, tt.wantPing)
+			}
+			if c.IsSuspended() != tt.wantSuspended {
+				t.Errorf("IsSuspended() = %v, want %v", c.IsSuspended(), tt.wantSuspended)
+			}
+			if closed := c.DisconnectReason() == DisconnectTimeout; closed != tt.wantClosed {
+				t.Errorf("timed out = %v, want %v", closed, tt.wantClosed)
+			}
+		})
+	}
+}
+
+func TestHandleHeartbeat(t *testing.T) {
+	c := newTestConnection(DefaultConfig())
+	c.lastReceived = time.Now().Add(-time.Second)
+
+	// A PING is answered with a PONG echoing its payload
+	ping := &Packet{Type: PING, Ack: ^uint16(0), Data: []byte("12345678")}
+	if err := c.HandleIncomingPacket(ping); err != nil {
+		t.Fatalf("HandleIncomingPacket(PING) error = %v", err)
+	}
+	sent := drainOutbound(c)
+	if len(sent) != 1 || sent[0].Type != PONG || !bytes.Equal(sent[0].Data, ping.Data) {
+		t.Fatalf("answered PING with %v, want one PONG echoing its payload", sent)
+	}
+	if time.Since(c.lastReceived) > 100*time.Millisecond {
+		t.Error("PING did not count as activity")
+	}
+
+	// A PONG echoing our send time gives a round-trip sample
+	data := binary.LittleEndian.AppendUint64(nil, uint64(time.Now().Add(-50*time.Millisecond).UnixNano()))
+	if err := c.HandleIncomingPacket(&Packet{Type: PONG, Ack: ^uint16(0), Data: data}); err != nil {
+		t.Fatalf("HandleIncomingPacket(PONG) error = %v", err)
+	}
+	if rtt := c.RTT(); rtt < 50*time.Millisecond || rtt > time.Second {
+		t.Errorf("RTT() = %v after a PONG sent 50ms ago", rtt)
+	}
+	if len(c.outbound) != 0 {
+		t.Error("PONG was answered")
+	}
+}
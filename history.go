@@ -0,0 +1,59 @@
+package rudp
+
+// receivedHistorySize is how many recent sequences are remembered for
+// duplicate detection and for acking retransmissions the ack bits no longer cover
+const receivedHistorySize = 1024
+
+// receivedHistory remembers which of the most recent sequences were received.
+// Each sequence maps to a fixed slot, which holds the sequence last stored there.
+type receivedHistory struct {
+	slots [receivedHistorySize]int32 // Sequence stored in each slot, -1 if none
+}
+
+// newReceivedHistory creates an empty history
+func newReceivedHistory() *receivedHistory {
+	h := &receivedHistory{}
+	h.clear()
+	return h
+}
+
+// clear forgets every sequence
+func (h *receivedHistory) clear() {
+	for i := range h.slots {
+		h.slots[i] = -1
+	}
+}
+
+// has reports whether seq was received
+func (h *receivedHistory) has(seq uint16) bool {
+	return h.slots[seq%receivedHistorySize] == int32(seq)
+}
+
+// insert marks seq as received
+func (h *receivedHistory) insert(seq uint16) {
+	h.slots[seq%receivedHistorySize] = int32(seq)
+}
+
+// advance forgets the sequences skipped when the newest sequence moves from
+// newest to seq, so stale slots from earlier wraparounds are not mistaken for them
+func (h *receivedHistory) advance(newest, seq uint16) {
+	gap := seq - newest
+	if gap > receivedHistorySize {
+		h.clear()
+		return
+	}
+	for s := newest + 1; s != seq; s++ {
+		h.slots[s%receivedHistorySize] = -1
+	}
+}
+
+// ackBits returns the acknowledgment bitfield for the 32 sequences before ack
+func (h *receivedHistory) ackBits(ack uint16) uint32 {
+	var bits uint32
+	for i := uint16(0); i < 32; i++ {
+		if h.has(ack - i - 1) {
+			bits |= 1 << i
+		}
+	}
+	return bits
+}
@@ -0,0 +1,105 @@
+// Package metrics serves the statistics of an rudp.Server in the Prometheus text
+// exposition format, so a server can be scraped without linking the Prometheus
+// client library into the game.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/cbodonnell/rudp"
+)
+
+// ContentType is the media type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Kinds of metric
+const (
+	counter = "counter"
+	gauge   = "gauge"
+)
+
+// metric describes one exported value of rudp.ServerStats
+type metric struct {
+	name  string
+	kind  string
+	help  string
+	value func(s *rudp.ServerStats) float64
+}
+
+var metrics = []metric{
+	{"connections", gauge, "Clients currently connected.",
+		func(s *rudp.ServerStats) float64 { return float64(s.Connections) }},
+	{"handshakes_accepted_total", counter, "Clients accepted as new connections.",
+		func(s *rudp.ServerStats) float64 { return float64(s.HandshakesAccepted) }},
+	{"handshakes_rejected_total", counter, "Connection attempts rejected, for any reason.",
+		func(s *rudp.ServerStats) float64 { return float64(s.HandshakesRejected) }},
+	{"datagrams_sent_total", counter, "Datagrams sent to clients, including retransmissions and control packets.",
+		func(s *rudp.ServerStats) float64 { return float64(s.PacketsSent) }},
+	{"datagrams_received_total", counter, "Datagrams received from clients and accepted.",
+		func(s *rudp.ServerStats) float64 { return float64(s.PacketsReceived) }},
+	{"bytes_sent_total", counter, "Size of the datagrams sent to clients.",
+		func(s *rudp.ServerStats) float64 { return float64(s.BytesSent) }},
+	{"bytes_received_total", counter, "Size of the datagrams received from clients.",
+		func(s *rudp.ServerStats) float64 { return float64(s.BytesReceived) }},
+	{"retransmissions_total", counter, "Reliable packets sent again because no ack arrived in time.",
+		func(s *rudp.ServerStats) float64 { return float64(s.Retransmissions) }},
+	{"delivery_failures_total", counter, "Reliable messages given up on after MaxRetransmissions.",
+		func(s *rudp.ServerStats) float64 { return float64(s.DeliveryFailures) }},
+	{"duplicates_total", counter, "Packets received more than once.",
+		func(s *rudp.ServerStats) float64 { return float64(s.Duplicates) }},
+	{"out_of_order_total", counter, "Packets received after a newer one.",
+		func(s *rudp.ServerStats) float64 { return float64(s.OutOfOrder) }},
+	{"decode_errors_total", counter, "Datagrams dropped because they were corrupted or not packets of this protocol version.",
+		func(s *rudp.ServerStats) float64 { return float64(s.DroppedPackets) }},
+	{"reassembly_refusals_total", counter, "Fragments refused for lack of reassembly memory, left for clients to retransmit.",
+		func(s *rudp.ServerStats) float64 { return float64(s.ReassemblyRefusals) }},
+	{"order_window_refusals_total", counter, "Reliable ordered packets refused for being too far ahead of their stream, left for clients to retransmit.",
+		func(s *rudp.ServerStats) float64 { return float64(s.OrderWindowRefusals) }},
+	{"late_ordered_total", counter, "Ordered packets dropped because their stream had already moved past them.",
+		func(s *rudp.ServerStats) float64 { return float64(s.LateOrdered) }},
+	{"outbound_drops_total", counter, "Batched unreliable messages dropped because the outbound buffer was full when their batch was sent.",
+		func(s *rudp.ServerStats) float64 { return float64(s.OutboundDrops) }},
+	{"packet_loss_ratio", gauge, "Estimated share of reliable packets lost.",
+		func(s *rudp.ServerStats) float64 { return s.PacketLoss / 100 }},
+	{"rtt_seconds", gauge, "Smoothed round-trip time, averaged over current connections.",
+		func(s *rudp.ServerStats) float64 { return s.RTT.Seconds() }},
+	{"pending_acks", gauge, "Reliable packets in flight across current connections.",
+		func(s *rudp.ServerStats) float64 { return float64(s.PendingAcks) }},
+	{"queued_bytes", gauge, "Bytes waiting to be sent across current connections.",
+		func(s *rudp.ServerStats) float64 { return float64(s.QueuedBytes) }},
+	{"inbound_queued", gauge, "Packets waiting to be handled by the application across current connections.",
+		func(s *rudp.ServerStats) float64 { return float64(s.InboundQueued) }},
+	{"outbound_queued", gauge, "Packets waiting for the socket across current connections.",
+		func(s *rudp.ServerStats) float64 { return float64(s.OutboundQueued) }},
+}
+
+// Handler returns an http.Handler serving the metrics of a server, with names
+// prefixed by "rudp_"
+func Handler(server *rudp.Server) http.Handler {
+	return HandlerWithNamespace(server, "rudp")
+}
+
+// HandlerWithNamespace returns an http.Handler serving the metrics of a server, with
+// names prefixed by the namespace and an underscore, for processes running several servers
+func HandlerWithNamespace(server *rudp.Server, namespace string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		Write(w, namespace, server.Stats())
+	})
+}
+
+// Write writes stats in the text exposition format, with names prefixed by the
+// namespace and an underscore
+func Write(w io.Writer, namespace string, stats rudp.ServerStats) error {
+	buf := bufio.NewWriter(w)
+	for _, m := range metrics {
+		name := namespace + "_" + m.name
+		buf.WriteString("# HELP " + name + " " + m.help + "\n")
+		buf.WriteString("# TYPE " + name + " " + m.kind + "\n")
+		buf.WriteString(name + " " + strconv.FormatFloat(m.value(&stats), 'g', -1, 64) + "\n")
+	}
+	return buf.Flush()
+}
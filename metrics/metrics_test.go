@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cbodonnell/rudp"
+)
+
+func TestWrite(t *testing.T) {
+	stats := rudp.ServerStats{
+		Stats: rudp.Stats{
+			PacketsSent:        1500,
+			ReassemblyRefusals: 2,
+			LateOrdered:        1,
+			PacketLoss:         12.5,
+			RTT:                25 * time.Millisecond,
+		},
+		Connections:    4,
+		DroppedPackets: 7,
+	}
+
+	var out strings.Builder
+	if err := Write(&out, "game", stats); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 3*len(metrics) {
+		t.Fatalf("wrote %d lines, want HELP, TYPE and value lines for %d metrics", len(lines), len(metrics))
+	}
+
+	tests := []struct {
+		name  string
+		kind  string
+		help  string
+		value string
+	}{
+		{"game_connections", gauge, "Clients currently connected.", "4"},
+		{"game_datagrams_sent_total", counter, "Datagrams sent to clients, including retransmissions and control packets.", "1500"},
+		{"game_decode_errors_total", counter, "Datagrams dropped because they were corrupted or not packets of this protocol version.", "7"},
+		{"game_reassembly_refusals_total", counter, "Fragments refused for lack of reassembly memory, left for clients to retransmit.", "2"},
+		{"game_order_window_refusals_total", counter, "Reliable ordered packets refused for being too far ahead of their stream, left for clients to retransmit.", "0"},
+		{"game_late_ordered_total", counter, "Ordered packets dropped because their stream had already moved past them.", "1"},
+		{"game_outbound_drops_total", counter, "Batched unreliable messages dropped because the outbound buffer was full when their batch was sent.", "0"},
+		{"game_packet_loss_ratio", gauge, "Estimated share of reliable packets lost.", "0.125"},
+		{"game_rtt_seconds", gauge, "Smoothed round-trip time, averaged over current connections.", "0.025"},
+		{"game_retransmissions_total", counter, "Reliable packets sent again because no ack arrived in time.", "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := []string{
+				"# HELP " + tt.name + " " + tt.help,
+				"# TYPE " + tt.name + " " + tt.kind,
+				tt.name + " " + tt.value,
+			}
+			for i := 0; i < len(lines); i += 3 {
+				if lines[i] == want[0] {
+					if got := lines[i : i+3]; strings.Join(got, "\n") != strings.Join(want, "\n") {
+						t.Errorf("wrote\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+					}
+					return
+				}
+			}
+			t.Errorf("no %q metric written", tt.name)
+		})
+	}
+
+	// Every metric is in the namespace
+	for _, line := range lines {
+		name := strings.TrimPrefix(strings.TrimPrefix(line, "# HELP "), "# TYPE ")
+		if !strings.HasPrefix(name, "game_") {
+			t.Errorf("line %q is outside the namespace", line)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package rudp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"net"
+	"time"
+)
+
+// A client whose address changes, such as a phone moving between networks, keeps its
+// connection. Packets from a new address do not move the connection by themselves:
+// the server sends a PATH_CHALLENGE there and only moves the connection once the
+// client answers with a PATH_RESPONSE proving it holds the path key derived from the
+// connection token, so guessing a ClientID is not enough to hijack a connection.
+// Until then, secure connections still process authenticated packets from the new
+// address while others drop them. A connection moves at most once per MigrationInterval.
+const (
+	pathChallengeSize     = 8                      // Random bytes the client must answer
+	pathChallengeInterval = 250 * time.Millisecond // How often a challenge may be sent or resent
+)
+
+// pathChallenge is a challenge sent to an address the connection may move to
+type pathChallenge struct {
+	addr   *net.UDPAddr
+	data   []byte
+	sentAt time.Time
+}
+
+// pathProof computes the answer to a path challenge
+func pathProof(key []byte, challenge []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(challenge)
+	return mac.Sum(nil)[:cookieMACSize]
+}
+
+// handleMigration handles a packet of a known client that arrived from a new address
+func (s *Server) handleMigration(conn *Connection, packet *Packet, addr *net.UDPAddr) error {
+	if packet.Type == PATH_RESPONSE {
+		if err := conn.validatePath(packet, addr); err != nil {
+			return err
+		}
+		s.migrate(conn, addr)
+		return nil
+	}
+
+	if !conn.IsSecure() {
+		conn.challengePath(addr)
+		return ErrPathNotValidated
+	}
+	if err := conn.HandleIncomingPacket(packet); err != nil {
+		return err
+	}
+	conn.challengePath(addr)
+	return nil
+}
+
+// migrate moves a connection to an address the client proved it owns
+func (s *Server) migrate(conn *Connection, addr *net.UDPAddr) {
+	conn.mu.Lock()
+	oldAddr := conn.addr
+	conn.addr = addr
+	conn.lastMigration = time.Now()
+	conn.challenge = nil
+	conn.restartMTUDiscovery()
+	conn.mu.Unlock()
+
+	if s.OnAddressChanged != nil {
+		s.OnAddressChanged(conn, oldAddr, addr)
+	}
+}
+
+// mayMigrate reports whether MigrationInterval has passed since the connection last moved
+func (c *Connection) mayMigrate(now time.Time) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return now.Sub(c.lastMigration) >= c.config.MigrationInterval
+}
+
+// challengePath sends a PATH_CHALLENGE to an address the connection may move to, unless
+// it moved too recently or a challenge was sent moments ago
+func (c *Connection) challengePath(addr *net.UDPAddr) {
+	c.mu.Lock()
+	now := time.Now()
+	if c.pathKey == nil || now.Sub(c.lastMigration) < c.config.MigrationInterval ||
+		(c.challenge != nil && now.Sub(c.challenge.sentAt) < pathChallengeInterval) {
+		c.mu.Unlock()
+		return
+	}
+
+	data := make([]byte, pathChallengeSize)
+	rand.Read(data)
+	c.challenge = &pathChallenge{addr: addr, data: data, sentAt: now}
+	c.mu.Unlock()
+
+	packet := &Packet{
+		Type:     PATH_CHALLENGE,
+		ClientID: c.clientID,
+		Data:     data,
+	}
+	c.conn.WriteToUDP(c.encode(packet), addr)
+}
+
+// validatePath checks that a PATH_RESPONSE from a new address answers the challenge sent there
+func (c *Connection) validatePath(packet *Packet, addr *net.UDPAddr) error {
+	if err := c.authenticate(packet); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	challenge := c.challenge
+	if challenge == nil || challenge.addr.String() != addr.String() ||
+		!hmac.Equal(packet.Data, pathProof(c.pathKey, challenge.data)) {
+		c.mu.Unlock()
+		return ErrInvalidProof
+	}
+	c.lastReceived = time.Now()
+	c.processAcknowledgments(packet.Ack, packet.AckBits)
+	c.mu.Unlock()
+
+	if c.IsSuspended() {
+		c.resume()
+	}
+	return nil
+}
+
+// handlePathChallenge answers a PATH_CHALLENGE the server sent to our current address
+func (c *Connection) handlePathChallenge(packet *Packet) {
+	if c.pathKey == nil {
+		return
+	}
+	c.queueControl(PATH_RESPONSE, pathProof(c.pathKey, packet.Data))
+}
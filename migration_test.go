@@ -0,0 +1,61 @@
+package rudp
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestValidatePath(t *testing.T) {
+	challenged := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 9000}
+	other := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 9000}
+
+	tests := []struct {
+		name    string
+		addr    *net.UDPAddr
+		proof   func(c *Connection) []byte
+		wantErr error
+	}{
+		{"valid", challenged, func(c *Connection) []byte {
+			return pathProof(c.pathKey, c.challenge.data)
+		}, nil},
+		{"wrong proof", challenged, func(c *Connection) []byte {
+			return pathProof(make([]byte, 32), c.challenge.data)
+		}, ErrInvalidProof},
+		{"wrong address", other, func(c *Connection) []byte {
+			return pathProof(c.pathKey, c.challenge.data)
+		}, ErrInvalidProof},
+		{"no challenge", challenged, func(c *Connection) []byte {
+			c.challenge = nil
+			return pathProof(c.pathKey, make([]byte, pathChallengeSize))
+		}, ErrInvalidProof},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConnection(DefaultConfig())
+			c.pathKey = []byte("path key")
+			c.challenge = &pathChallenge{addr: challenged, data: []byte("12345678"), sentAt: time.Now()}
+
+			response := &Packet{Type: PATH_RESPONSE, Ack: ^uint16(0), Data: tt.proof(c)}
+			if err := c.validatePath(response, tt.addr); !errors.Is(err, tt.wantErr) {
+				t.Errorf("validatePath() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChallengePathMigrationInterval(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 9000}
+
+	c := newTestConnection(DefaultConfig())
+	c.pathKey = []byte("path key")
+	(&Server{}).migrate(c, addr)
+
+	// Having just moved, the connection does not challenge another address
+	c.challengePath(&net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 9000})
+	if c.challenge != nil {
+		t.Error("challengePath() sent a challenge within MigrationInterval of the last move")
+	}
+}
@@ -0,0 +1,240 @@
+package rudp
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// With Config.PathMTUDiscovery set, a connection starts out sending datagrams of at most
+// baseMTU bytes, which nearly every path carries, and probes for the largest size up to
+// MaxPacketSize that reaches the peer. Probes are MTU_PROBE packets padded to the size
+// being tried, each answered by the peer with an MTU_PROBE_ACK. A binary search narrows
+// the range between the largest size acked and the smallest size lost mtuProbeAttempts
+// times in a row. It is repeated every MTUProbeInterval, and started over after migrating
+// or resuming, each time trying the current MTU first. The MTU only goes down once a
+// probe of its size was lost, so a new address alone does not cost any messages.
+// Fragmentation and batching use the discovered MTU.
+const (
+	baseMTU            = 1200 // Datagram size assumed to reach any peer, as in QUIC
+	mtuSearchPrecision = 16   // The search ends once the range is this narrow, in bytes
+	mtuProbeAttempts   = 3    // Times a probe is lost before its size is considered too large
+	mtuProbeIDSize     = 2    // Probe ID echoed by MTU_PROBE_ACK
+)
+
+// mtuSearch is the state of a connection's path MTU discovery
+type mtuSearch struct {
+	low      int       // Largest size known to get through
+	high     int       // Smallest size known not to get through
+	size     int       // Size of the outstanding probe, 0 if none
+	id       uint16    // ID of the outstanding probe
+	attempts int       // Times the outstanding probe was sent
+	sentAt   time.Time // When the outstanding probe was last sent
+	next     time.Time // When the search is repeated, zero while searching
+}
+
+// MTU returns the largest datagram the connection sends: the discovered path MTU with
+// Config.PathMTUDiscovery set, MaxPacketSize otherwise
+func (c *Connection) MTU() int {
+	return int(c.mtu.Load())
+}
+
+// baseMTU returns the size path MTU discovery starts from
+func (c *Connection) baseMTU() int {
+	return min(baseMTU, c.config.MaxPacketSize)
+}
+
+// startMTUDiscovery sets the MTU a new connection starts with and prepares the search
+func (c *Connection) startMTUDiscovery() {
+	if !c.config.PathMTUDiscovery {
+		c.mtu.Store(int32(c.config.MaxPacketSize))
+		return
+	}
+	c.mtu.Store(int32(c.baseMTU()))
+	c.restartMTUDiscovery()
+}
+
+// restartMTUDiscovery searches the path from scratch, as after moving to a new address.
+// The current MTU is kept, and packets already sent keep being retransmitted at their
+// size, until the first probe, which revalidates it, shows the path no longer carries
+// it. Must be called with the lock held.
+func (c *Connection) restartMTUDiscovery() {
+	if !c.config.PathMTUDiscovery {
+		return
+	}
+	c.mtuSearch = mtuSearch{
+		low:  c.baseMTU(),
+		high: c.config.MaxPacketSize + 1,
+		id:   c.mtuSearch.id,
+	}
+}
+
+// checkMTU sends the next path MTU probe, resends one that went unanswered, or starts
+// the search over once MTUProbeInterval passed. It returns the messages given up on
+// because the MTU shrank, see lowerMTU. Must be called with the lock held.
+func (c *Connection) checkMTU(now time.Time) []*Packet {
+	if !c.config.PathMTUDiscovery || c.config.MaxPacketSize+1-c.baseMTU() <= mtuSearchPrecision {
+		return nil
+	}
+	s := &c.mtuSearch
+
+	var failed []*Packet
+	if s.size != 0 {
+		if now.Sub(s.sentAt) <= c.rtt.timeout(s.attempts) {
+			return nil
+		}
+		if s.attempts < mtuProbeAttempts {
+			c.sendMTUProbe(now)
+			return nil
+		}
+		// Lost every time, so the size is too large for the path
+		s.high = s.size
+		if s.size <= c.MTU() {
+			failed = c.lowerMTU(s.low)
+		}
+		s.size = 0
+	}
+
+	if s.high-s.low <= mtuSearchPrecision {
+		if s.next.IsZero() {
+			s.next = now.Add(c.config.MTUProbeInterval)
+		}
+		if now.Before(s.next) {
+			return failed
+		}
+		s.low = c.baseMTU()
+		s.high = c.config.MaxPacketSize + 1
+		s.next = time.Time{}
+	}
+
+	// Revalidate the current MTU before searching above it
+	s.size = (s.low + s.high) / 2
+	if mtu := c.MTU(); mtu > s.low && mtu < s.high {
+		s.size = mtu
+	}
+	s.id++
+	s.attempts = 0
+	c.sendMTUProbe(now)
+	return failed
+}
+
+// lowerMTU sets an MTU that may be smaller than the current one, once a probe showed the
+// path no longer carries the current one, and makes the packets waiting to be sent fit it: backlogged messages are fragmented again, and batches being
+// filled are sent as separate messages. Reliable packets already sent that no longer fit
+// are given up on, since sending their data again in new packets could deliver it twice,
+// as are messages that cannot be fragmented. It returns the messages given up on.
+// Must be called with the lock held.
+func (c *Connection) lowerMTU(mtu int) []*Packet {
+	shrunk := mtu < c.MTU()
+	c.mtu.Store(int32(mtu))
+	if !shrunk {
+		return nil
+	}
+
+	var failed []*Packet
+	for seq, packet := range c.pendingAcks {
+		if _, exists := c.pendingAcks[seq]; exists && c.wireSize(packet) > mtu {
+			failed = append(failed, c.failPacket(packet)...)
+		}
+	}
+
+	backlog := c.backlog
+	c.backlog = nil
+	lost := make(map[*Packet]bool) // Fragmented messages given up on
+	for _, packet := range backlog {
+		switch {
+		case packet.message != nil && lost[packet.message]:
+		case c.wireSize(packet) <= mtu:
+			c.backlog = append(c.backlog, packet)
+		case packet.Type == FRAGMENT:
+			// Other fragments of the message may already be on their way
+			lost[packet.message] = true
+			failed = append(failed, c.failPacket(packet)...)
+		case packet.Type == BATCH:
+			for _, message := range packet.batch {
+				failed = append(failed, c.refragment(message)...)
+			}
+		default:
+			failed = append(failed, c.refragment(packet)...)
+		}
+	}
+
+	for index, batch := range c.batches {
+		if batch != nil && c.wireSize(batch) > mtu {
+			c.batches[index] = nil
+			for _, message := range batch.batch {
+				failed = append(failed, c.refragment(message)...)
+			}
+		}
+	}
+
+	c.counters.deliveryFailures.Add(uint64(len(failed)))
+	return failed
+}
+
+// refragment queues an unsent message again, split into fragments if it no longer fits
+// in a packet. It returns the message if it had to be given up on and was reliable.
+// Must be called with the lock held.
+func (c *Connection) refragment(message *Packet) []*Packet {
+	if c.wireSize(message) <= c.MTU() {
+		c.queue(message)
+		return nil
+	}
+
+	fragments, err := c.fragment(message)
+	if err != nil {
+		if !message.IsReliable() {
+			return nil
+		}
+		return c.failPacket(message)
+	}
+	if message.receipt != nil {
+		message.receipt.remaining += len(fragments) - 1
+	}
+	for _, fragment := range fragments {
+		fragment.OrderSequence = message.OrderSequence
+		c.queue(fragment)
+	}
+	return nil
+}
+
+// sendMTUProbe sends the outstanding probe, padded to the size being tried.
+// Must be called with the lock held.
+func (c *Connection) sendMTUProbe(now time.Time) {
+	s := &c.mtuSearch
+	data := make([]byte, s.size-c.wireSize(&Packet{}))
+	binary.LittleEndian.PutUint16(data, s.id)
+	s.attempts++
+	s.sentAt = now
+	c.queueControlLocked(MTU_PROBE, data)
+}
+
+// handleMTUProbe answers a path MTU probe of the peer
+func (c *Connection) handleMTUProbe(packet *Packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastReceived = time.Now()
+	c.processAcknowledgments(packet.Ack, packet.AckBits)
+	if len(packet.Data) >= mtuProbeIDSize {
+		c.queueControlLocked(MTU_PROBE_ACK, packet.Data[:mtuProbeIDSize])
+	}
+}
+
+// handleMTUProbeAck raises the MTU to the size of the probe the peer received
+func (c *Connection) handleMTUProbeAck(packet *Packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastReceived = time.Now()
+	c.processAcknowledgments(packet.Ack, packet.AckBits)
+
+	s := &c.mtuSearch
+	if s.size == 0 || len(packet.Data) != mtuProbeIDSize || binary.LittleEndian.Uint16(packet.Data) != s.id {
+		return
+	}
+	s.low = s.size
+	if s.size > c.MTU() {
+		c.mtu.Store(int32(s.size))
+	}
+	s.size = 0
+}
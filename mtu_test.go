@@ -0,0 +1,237 @@
+package rudp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// newMTUTestConnection creates a connection discovering the path MTU up to 1400 bytes
+func newMTUTestConnection() *Connection {
+	config := DefaultConfig()
+	config.PathMTUDiscovery = true
+	config.MaxPacketSize = 1400
+	return newTestConnection(config)
+}
+
+// runMTUSearch drives path MTU discovery over a path carrying datagrams of up to
+// pathMTU bytes, starting at now, until the search settles. It returns the messages
+// given up on along the way and the time the search settled.
+func runMTUSearch(t *testing.T, c *Connection, pathMTU int, now time.Time) ([]*Packet, time.Time) {
+	t.Helper()
+	var failed []*Packet
+	for i := 0; i < 100; i++ {
+		c.mu.Lock()
+		failed = append(failed, c.checkMTU(now)...)
+		searching := c.mtuSearch.size != 0
+		c.mu.Unlock()
+		if !searching {
+			return failed, now
+		}
+
+		for _, packet := range drainOutbound(c) {
+			if packet.Type == MTU_PROBE && c.wireSize(packet) <= pathMTU {
+				// Acks none of our packets, only the probe
+				c.handleMTUProbeAck(&Packet{Type: MTU_PROBE_ACK, Ack: ^uint16(0), Data: packet.Data[:mtuProbeIDSize]})
+			}
+		}
+		now = now.Add(time.Hour)
+	}
+	t.Fatal("path MTU search did not settle")
+	return nil, now
+}
+
+func TestCheckMTUSearch(t *testing.T) {
+	tests := []struct {
+		name    string
+		pathMTU int
+	}{
+		{"base only", baseMTU},
+		{"in between", 1300},
+		{"just below the maximum", 1390},
+		{"maximum", 1400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newMTUTestConnection()
+			if c.MTU() != baseMTU {
+				t.Fatalf("MTU() = %d before probing, want %d", c.MTU(), baseMTU)
+			}
+
+			runMTUSearch(t, c, tt.pathMTU, time.Now())
+			if mtu := c.MTU(); mtu > tt.pathMTU || mtu < tt.pathMTU-mtuSearchPrecision {
+				t.Errorf("MTU() = %d, want within %d bytes below %d", mtu, mtuSearchPrecision, tt.pathMTU)
+			}
+		})
+	}
+}
+
+func TestCheckMTULowersOnProbeLoss(t *testing.T) {
+	c := newMTUTestConnection()
+	_, now := runMTUSearch(t, c, 1400, time.Now())
+	discovered := c.MTU()
+
+	receipt := newReceipt()
+	if err := c.send(0, make([]byte, discovered-c.wireSize(&Packet{})), Reliable, receipt); err != nil {
+		t.Fatalf("send() error = %v", err)
+	}
+	drainOutbound(c)
+
+	// The path shrinks; the next search starts by revalidating the current MTU
+	failed, _ := runMTUSearch(t, c, 1250, now.Add(DefaultMTUProbeInterval+time.Hour))
+	if mtu := c.MTU(); mtu > 1250 || mtu < 1250-mtuSearchPrecision {
+		t.Errorf("MTU() = %d, want within %d bytes below 1250", mtu, mtuSearchPrecision)
+	}
+	if len(failed) != 1 || receipt.Status() != DeliveryFailed {
+		t.Errorf("gave up on %d messages with receipt %v, want the one too large for the path", len(failed), receipt.Status())
+	}
+	if len(c.pendingAcks) != 0 {
+		t.Errorf("%d packets still pending", len(c.pendingAcks))
+	}
+	if got := c.counters.deliveryFailures.Load(); got != 1 {
+		t.Errorf("deliveryFailures = %d, want 1", got)
+	}
+}
+
+func TestRevalidateMTUAfterMoving(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 9000}
+	tests := []struct {
+		name string
+		move func(c *Connection)
+	}{
+		{"resume", func(c *Connection) {
+			c.suspend()
+			c.resume()
+		}},
+		{"migrate", func(c *Connection) {
+			(&Server{}).migrate(c, addr)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.PathMTUDiscovery = true
+			config.MaxPacketSize = 1400
+			config.ResumeGracePeriod = time.Minute
+			c := newTestConnection(config)
+			_, now := runMTUSearch(t, c, 1400, time.Now())
+			discovered := c.MTU()
+			if discovered <= baseMTU {
+				t.Fatalf("MTU() = %d after probing, want above %d", discovered, baseMTU)
+			}
+
+			// A message larger than the base MTU is in flight while the connection moves
+			receipt := newReceipt()
+			if err := c.send(0, make([]byte, 1300-c.wireSize(&Packet{})), Reliable, receipt); err != nil {
+				t.Fatalf("send() error = %v", err)
+			}
+			drainOutbound(c)
+
+			tt.move(c)
+			if c.MTU() != discovered {
+				t.Errorf("MTU() = %d after moving, want %d kept until revalidated", c.MTU(), discovered)
+			}
+			if len(c.pendingAcks) != 1 || receipt.Status() != DeliveryPending {
+				t.Fatalf("%d packets pending with receipt %v after moving, want the message kept", len(c.pendingAcks), receipt.Status())
+			}
+
+			// The first probe revalidates the current MTU, and the path still carries it
+			c.mu.Lock()
+			failed := c.checkMTU(now.Add(time.Hour))
+			probeSize := c.mtuSearch.size
+			c.mu.Unlock()
+			if probeSize != discovered {
+				t.Errorf("first probe after moving is %d bytes, want %d", probeSize, discovered)
+			}
+			failed2, _ := runMTUSearch(t, c, 1400, now.Add(time.Hour))
+			if failed = append(failed, failed2...); len(failed) != 0 {
+				t.Errorf("gave up on %d messages, want none", len(failed))
+			}
+			if c.MTU() < discovered || receipt.Status() != DeliveryPending {
+				t.Errorf("MTU() = %d with receipt %v, want at least %d with the message still pending", c.MTU(), receipt.Status(), discovered)
+			}
+		})
+	}
+}
+
+func TestLowerMTU(t *testing.T) {
+	config := DefaultConfig()
+	config.BatchInterval = time.Hour
+	c := newTestConnection(config)
+	overhead := c.wireSize(&Packet{})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Sent before the MTU shrank: the large one can no longer be resent
+	sent := &Packet{Type: DATA, Mode: Reliable, Data: make([]byte, 1300-overhead), receipt: newReceipt()}
+	small := &Packet{Type: DATA, Mode: Reliable, Data: make([]byte, 100), receipt: newReceipt()}
+	c.admit(sent)
+	c.admit(small)
+
+	// Waiting for the congestion window: the message can be fragmented again,
+	// the fragments of another message cannot
+	backlogged := &Packet{Type: DATA, Mode: Reliable, Data: make([]byte, 1300-overhead), receipt: newReceipt()}
+	fragmented := &Packet{Type: DATA, Mode: Reliable, Data: make([]byte, 3000), receipt: newReceipt()}
+	fragments, err := c.fragment(fragmented)
+	if err != nil {
+		t.Fatalf("fragment() error = %v", err)
+	}
+	fragmented.receipt.remaining = len(fragments)
+	c.backlog = append([]*Packet{backlogged}, fragments...)
+
+	// Being batched: the batch is split into its messages
+	for i := 0; i < 2; i++ {
+		if err := c.addToBatch(&Packet{Type: DATA, Mode: Unreliable, Data: make([]byte, 650)}); err != nil {
+			t.Fatalf("addToBatch() error = %v", err)
+		}
+	}
+	c.batchTimer.Stop()
+	drainOutbound(c)
+
+	failed := c.lowerMTU(baseMTU)
+	if c.MTU() != baseMTU {
+		t.Errorf("MTU() = %d, want %d", c.MTU(), baseMTU)
+	}
+	if len(failed) != 2 || c.counters.deliveryFailures.Load() != 2 {
+		t.Errorf("gave up on %d messages and counted %d, want 2", len(failed), c.counters.deliveryFailures.Load())
+	}
+	for name, receipt := range map[string]*Receipt{"sent": sent.receipt, "fragmented": fragmented.receipt} {
+		if receipt.Status() != DeliveryFailed {
+			t.Errorf("%s message is %v, want %v", name, receipt.Status(), DeliveryFailed)
+		}
+	}
+	for name, receipt := range map[string]*Receipt{"small": small.receipt, "backlogged": backlogged.receipt} {
+		if receipt.Status() != DeliveryPending {
+			t.Errorf("%s message is %v, want %v", name, receipt.Status(), DeliveryPending)
+		}
+	}
+	if backlogged.receipt.remaining != 2 {
+		t.Errorf("backlogged message awaits %d packets, want 2 fragments", backlogged.receipt.remaining)
+	}
+	if c.batches[0] != nil {
+		t.Error("batch too large for the MTU is still being filled")
+	}
+
+	// Everything left to send fits, and the batched messages went out on their own
+	queued := append(drainOutbound(c), c.backlog...)
+	unbatched := 0
+	for _, packet := range queued {
+		if size := c.wireSize(packet); size > baseMTU {
+			t.Errorf("%v packet of %d bytes queued, over the MTU", packet.Type, size)
+		}
+		if packet.Type == DATA && packet.Mode == Unreliable {
+			unbatched++
+		}
+	}
+	for _, packet := range c.pendingAcks {
+		if size := c.wireSize(packet); size > baseMTU {
+			t.Errorf("%v packet of %d bytes pending, over the MTU", packet.Type, size)
+		}
+	}
+	if unbatched != 2 {
+		t.Errorf("%d batched messages sent on their own, want 2", unbatched)
+	}
+}
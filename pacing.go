@@ -0,0 +1,120 @@
+package rudp
+
+import (
+	"sync"
+	"time"
+)
+
+// Outbound datagrams are paced by token buckets refilled at Config.SendRate bytes per
+// second for each connection, and at Config.ServerSendRate for a server as a whole.
+// A bucket holds only a few milliseconds of traffic, so a large Broadcast leaves as a
+// steady stream rather than a burst that overflows router buffers.
+const pacingBurst = 10 * time.Millisecond // Traffic a full bucket may send back to back
+
+// tokenBucket limits a send rate in bytes per second. Datagrams are never split, so
+// a send may take more tokens than are available and leave the bucket in debt; later
+// sends wait for the debt to be paid off, which spaces them out in the order they
+// asked. A nil bucket does not limit anything.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // Bytes per second
+	burst  float64 // Most tokens the bucket holds
+	tokens float64
+	last   time.Time // When tokens were last added
+}
+
+// newTokenBucket creates a bucket for the given rate, or nil if the rate is unlimited.
+// It always holds at least one datagram of the given size.
+func newTokenBucket(rate int, maxPacketSize int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := max(float64(rate)*pacingBurst.Seconds(), float64(maxPacketSize))
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// reserve takes the tokens for a datagram of the given size and returns how long
+// to wait before sending it
+func (b *tokenBucket) reserve(size int, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.After(b.last) {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, b.burst)
+		b.last = now
+	}
+	b.tokens -= float64(size)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// pace waits until the send rate limits of the connection and its server allow a
+// datagram of the given size to go out. It returns false if the connection closed
+// in the meantime.
+func (c *Connection) pace(size int) bool {
+	now := time.Now()
+	wait := max(c.limiter.reserve(size, now), c.serverLimiter.reserve(size, now))
+	if wait <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// wireSize returns the size of a packet's datagram
+func (c *Connection) wireSize(packet *Packet) int {
+	size := c.config.overhead() + len(packet.Data)
+	if c.session != nil {
+		size += SecureOverhead
+	}
+	return size
+}
+
+// enqueue queues a packet for the socket without blocking and counts its bytes as
+// queued. It returns false if the buffer is full. Must be called with the lock held.
+func (c *Connection) enqueue(packet *Packet) bool {
+	size := int64(c.wireSize(packet))
+	c.queuedBytes.Add(size)
+	packet.queued = true
+
+	select {
+	case c.outbound <- packet:
+		return true
+	default:
+		c.queuedBytes.Add(-size)
+		packet.queued = false
+		return false
+	}
+}
+
+// QueuedBytes returns the bytes waiting to be sent, in the outbound buffer or held
+// back by congestion control. A growing value means the application sends faster than
+// the send rate or the network allows and should reduce its update rate.
+func (c *Connection) QueuedBytes() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	queued := int(c.queuedBytes.Load())
+	for _, packet := range c.backlog {
+		queued += c.wireSize(packet)
+	}
+	return queued
+}
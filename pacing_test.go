@@ -0,0 +1,67 @@
+package rudp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	type send struct {
+		size int
+		at   time.Duration // Since start
+		wait time.Duration
+	}
+
+	tests := []struct {
+		name  string
+		rate  int
+		sends []send
+	}{
+		{"within the burst", 100_000, []send{
+			{size: 500, wait: 0},
+			{size: 500, wait: 0},
+		}},
+		{"debt is paid off at the rate", 100_000, []send{
+			{size: 1000, wait: 0},
+			{size: 1000, wait: 10 * time.Millisecond},
+			{size: 1000, wait: 20 * time.Millisecond},
+		}},
+		{"refills over time", 100_000, []send{
+			{size: 1000, wait: 0},
+			{size: 1000, wait: 10 * time.Millisecond},
+			{size: 1000, at: 20 * time.Millisecond, wait: 0},
+		}},
+		{"refill is capped at the burst", 100_000, []send{
+			{size: 1000, at: time.Hour, wait: 0},
+			{size: 1000, at: time.Hour, wait: 10 * time.Millisecond},
+		}},
+		{"holds at least one datagram", 1000, []send{
+			{size: 1000, wait: 0},
+			{size: 500, wait: 500 * time.Millisecond},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTokenBucket(tt.rate, 1000)
+			b.last = start
+			for i, s := range tt.sends {
+				// Allow for floating point rounding
+				if got := b.reserve(s.size, start.Add(s.at)); got < s.wait-time.Microsecond || got > s.wait+time.Microsecond {
+					t.Errorf("send %d waits %v, want %v", i, got, s.wait)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenBucketUnlimited(t *testing.T) {
+	b := newTokenBucket(0, 1000)
+	if b != nil {
+		t.Fatal("newTokenBucket() with no rate returned a bucket")
+	}
+	if wait := b.reserve(MaxUDPPayloadSize, time.Now()); wait != 0 {
+		t.Errorf("nil bucket waits %v, want 0", wait)
+	}
+}
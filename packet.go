@@ -2,6 +2,7 @@ package rudp
 
 import (
 	"encoding/binary"
+	"hash/crc32"
 	"time"
 )
 
@@ -13,6 +14,19 @@ const (
 	CONNECT
 	CONNECT_ACK
 	DISCONNECT
+	PING              // Keepalive sent when a connection is idle, carries acks
+	PONG              // Reply to PING echoing its payload, carries acks
+	ACK               // Standalone acks, sent when no other packet carried them within AckDelay, carries how long they were held
+	FRAGMENT          // Part of a message too large for one packet, reassembled before delivery
+	CONNECT_CHALLENGE // Reply to CONNECT carrying a cookie the client must echo
+	CONNECT_RESPONSE  // Echo of the cookie proving the client owns its address
+	CONNECT_REJECT    // Reply to CONNECT_RESPONSE refusing the connection, carries a RejectReason
+	PATH_CHALLENGE    // Sent to a client's new address before the connection moves there
+	PATH_RESPONSE     // Answer to PATH_CHALLENGE proving the client holds the path key
+	BATCH             // Several small messages packed into one datagram, unpacked before delivery
+	MTU_PROBE         // Padded to the datagram size being tried by path MTU discovery
+	MTU_PROBE_ACK     // Answer to MTU_PROBE echoing its ID
+	ORDER_SKIP        // Takes the place of a ReliableOrdered message the sender gave up on, so later ones are not held up
 )
 
 // DeliveryMode defines how packets should be delivered
@@ -23,66 +37,149 @@ const (
 	UnreliableOrdered
 	Reliable
 	ReliableOrdered
+	UnreliableSequenced // Only the newest packet matters, older ones arriving late are dropped
 )
 
-const MaxPacketSize = 1400 // bytes
-
 // Packet represents a network packet with metadata
 type Packet struct {
-	Type      PacketType
-	ClientID  uint32 // Unique client identifier (in all packets)
-	ID        uint16
-	Sequence  uint16
-	Ack       uint16
-	AckBits   uint32
-	Mode      DeliveryMode
-	Timestamp int64
-	Data      []byte
-	Attempts  int
-	LastSent  time.Time
+	Type          PacketType
+	ClientID      uint32 // Unique client identifier (in all packets)
+	ID            uint16
+	Sequence      uint16 // Ack sequence, shared by all packets of a connection
+	Ack           uint16
+	AckBits       uint32
+	Mode          DeliveryMode
+	Channel       uint8  // Logical channel the packet was sent on
+	OrderSequence uint16 // Position within the stream of its channel and ordered delivery mode
+	Timestamp     int64
+	Data          []byte
+	Attempts      int
+	LastSent      time.Time
+
+	receipt *Receipt  // Resolved when a reliable packet is acked or given up on
+	message *Packet   // Message a FRAGMENT packet is part of
+	batch   []*Packet // Messages packed into a BATCH packet
+	queued  bool      // Whether the packet waits in the outbound buffer, so it is not retransmitted yet
+
+	checksummed bool // Whether the packet arrived with a valid checksum
 }
 
-const HeaderSize = 16 // Type(1) + ClientID(4) + Seq(2) + Ack(2) + AckBits(4) + Mode(1) + DataSize(2)
+// Every packet starts with ProtocolMagic and ProtocolVersion so stray traffic and peers
+// speaking another version of the protocol are recognized. Together with Type and ClientID
+// they form a prefix whose layout never changes between versions.
+const (
+	ProtocolMagic   uint16 = 0x5552 // "RU" on the wire
+	ProtocolVersion uint8  = 1
+)
+
+const HeaderSize = 22 // Magic(2) + Version(1) + Type(1) + ClientID(4) + Seq(2) + Ack(2) + AckBits(4) + Mode(1) + Channel(1) + OrderSeq(2) + DataSize(2)
+
+// Packets may be followed by a CRC32C of their header and payload, seeded with the
+// protocol identifier and Config.AppID, which is flagged in the high bit of the Type
+// byte. Packets of another application fail the check like corrupted ones. UDP's own
+// checksum is optional on IPv4 and too weak to keep a corrupted Ack from clearing
+// unrelated reliable packets.
+const (
+	ChecksumSize = 4 // Bytes the checksum adds to every packet when Config.Checksum is set
+	checksumFlag = 0x80
+)
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumSeed returns the initial CRC of an application's checksums
+func checksumSeed(appID uint32) uint32 {
+	buf := binary.LittleEndian.AppendUint16(nil, ProtocolMagic)
+	buf = append(buf, ProtocolVersion)
+	buf = binary.LittleEndian.AppendUint32(buf, appID)
+	return crc32.Checksum(buf, checksumTable)
+}
 
 // Marshal serializes the packet for network transmission
 func (p *Packet) Marshal() []byte {
 	// All packets use the same format (CONNECT/CONNECT_ACK just leave Seq/Ack/etc at 0)
-	buf := make([]byte, HeaderSize+len(p.Data))
-	buf[0] = byte(p.Type)
-	binary.LittleEndian.PutUint32(buf[1:5], p.ClientID)
-	binary.LittleEndian.PutUint16(buf[5:7], p.Sequence)
-	binary.LittleEndian.PutUint16(buf[7:9], p.Ack)
-	binary.LittleEndian.PutUint32(buf[9:13], p.AckBits)
-	buf[13] = byte(p.Mode)
-	binary.LittleEndian.PutUint16(buf[14:16], uint16(len(p.Data)))
-	copy(buf[HeaderSize:], p.Data)
+	return append(p.marshalHeader(len(p.Data)), p.Data...)
+}
+
+// marshalHeader serializes the header for a payload of the given size, leaving
+// capacity for the payload to be appended
+func (p *Packet) marshalHeader(dataSize int) []byte {
+	buf := make([]byte, HeaderSize, HeaderSize+dataSize)
+	binary.LittleEndian.PutUint16(buf[0:2], ProtocolMagic)
+	buf[2] = ProtocolVersion
+	buf[3] = byte(p.Type)
+	binary.LittleEndian.PutUint32(buf[4:8], p.ClientID)
+	binary.LittleEndian.PutUint16(buf[8:10], p.Sequence)
+	binary.LittleEndian.PutUint16(buf[10:12], p.Ack)
+	binary.LittleEndian.PutUint32(buf[12:16], p.AckBits)
+	buf[16] = byte(p.Mode)
+	buf[17] = p.Channel
+	binary.LittleEndian.PutUint16(buf[18:20], p.OrderSequence)
+	binary.LittleEndian.PutUint16(buf[20:22], uint16(dataSize))
 	return buf
 }
 
-// Unmarshal deserializes a packet from network data
+// Unmarshal deserializes a packet from network data. Datagrams without the protocol
+// magic are rejected with ErrInvalidPacket. For another protocol version only Type and
+// ClientID are decoded, and ErrVersionMismatch is returned. Checksummed packets are
+// verified as sent with the default AppID of 0 and rejected with ErrChecksumMismatch
+// if they were corrupted.
 func (p *Packet) Unmarshal(data []byte) error {
-	if len(data) < HeaderSize {
+	return p.unmarshal(data, checksumSeed(0))
+}
+
+// unmarshal deserializes a packet, verifying checksums with the given seed
+func (p *Packet) unmarshal(data []byte, seed uint32) error {
+	if len(data) < HeaderSize || binary.LittleEndian.Uint16(data[0:2]) != ProtocolMagic {
 		return ErrInvalidPacket
 	}
 
-	p.Type = PacketType(data[0])
-	p.ClientID = binary.LittleEndian.Uint32(data[1:5])
-	p.Sequence = binary.LittleEndian.Uint16(data[5:7])
-	p.Ack = binary.LittleEndian.Uint16(data[7:9])
-	p.AckBits = binary.LittleEndian.Uint32(data[9:13])
-	p.Mode = DeliveryMode(data[13])
-	dataSize := int(binary.LittleEndian.Uint16(data[14:16]))
+	p.Type = PacketType(data[3] &^ checksumFlag)
+	p.ClientID = binary.LittleEndian.Uint32(data[4:8])
+	if data[2] != ProtocolVersion {
+		return ErrVersionMismatch
+	}
+
+	p.Sequence = binary.LittleEndian.Uint16(data[8:10])
+	p.Ack = binary.LittleEndian.Uint16(data[10:12])
+	p.AckBits = binary.LittleEndian.Uint32(data[12:16])
+	p.Mode = DeliveryMode(data[16])
+	p.Channel = data[17]
+	p.OrderSequence = binary.LittleEndian.Uint16(data[18:20])
+	dataSize := int(binary.LittleEndian.Uint16(data[20:22]))
 
 	if len(data) < HeaderSize+dataSize {
 		return ErrInvalidPacket
 	}
 
+	p.checksummed = data[3]&checksumFlag != 0
+	if p.checksummed {
+		end := HeaderSize + dataSize
+		if len(data) < end+ChecksumSize {
+			return ErrInvalidPacket
+		}
+		if binary.LittleEndian.Uint32(data[end:]) != crc32.Update(seed, checksumTable, data[:end]) {
+			return ErrChecksumMismatch
+		}
+	}
+
 	p.Data = make([]byte, dataSize)
 	copy(p.Data, data[HeaderSize:HeaderSize+dataSize])
 
 	return nil
 }
 
+// appendChecksum flags a serialized packet as checksummed and appends its checksum,
+// computed from the given seed
+func appendChecksum(buf []byte, seed uint32) []byte {
+	buf[3] |= checksumFlag
+	return binary.LittleEndian.AppendUint32(buf, crc32.Update(seed, checksumTable, buf))
+}
+
+// packetVersion returns the protocol version of a datagram that passed Unmarshal's magic check
+func packetVersion(data []byte) uint8 {
+	return data[2]
+}
+
 // IsReliable returns true if this packet requires acknowledgment
 func (p *Packet) IsReliable() bool {
 	return p.Mode == Reliable || p.Mode == ReliableOrdered
@@ -90,5 +187,11 @@ func (p *Packet) IsReliable() bool {
 
 // IsOrdered returns true if this packet must be delivered in order
 func (p *Packet) IsOrdered() bool {
-	return p.Mode == UnreliableOrdered || p.Mode == ReliableOrdered
+	return p.Mode == UnreliableOrdered || p.Mode == ReliableOrdered || p.Mode == UnreliableSequenced
+}
+
+// IsSequenced returns true if this packet is dropped rather than buffered when it arrives
+// after a newer packet of its stream
+func (p *Packet) IsSequenced() bool {
+	return p.Mode == UnreliableSequenced
 }
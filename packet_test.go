@@ -0,0 +1,162 @@
+package rudp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet Packet
+	}{
+		{"empty", Packet{Type: PING}},
+		{"data", Packet{
+			Type:          DATA,
+			ClientID:      0xdeadbeef,
+			Sequence:      65535,
+			Ack:           1234,
+			AckBits:       0x80000001,
+			Mode:          ReliableOrdered,
+			Channel:       7,
+			OrderSequence: 42,
+			Data:          []byte("hello"),
+		}},
+		{"large", Packet{Type: FRAGMENT, Mode: Reliable, Data: bytes.Repeat([]byte{0xab}, 1400)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := tt.packet.Marshal()
+			if len(buf) != HeaderSize+len(tt.packet.Data) {
+				t.Fatalf("marshaled %d bytes, want %d", len(buf), HeaderSize+len(tt.packet.Data))
+			}
+
+			var got Packet
+			if err := got.Unmarshal(buf); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			want := tt.packet
+			if got.Type != want.Type || got.ClientID != want.ClientID || got.Sequence != want.Sequence ||
+				got.Ack != want.Ack || got.AckBits != want.AckBits || got.Mode != want.Mode ||
+				got.Channel != want.Channel || got.OrderSequence != want.OrderSequence {
+				t.Errorf("header = %+v, want %+v", got, want)
+			}
+			if !bytes.Equal(got.Data, want.Data) {
+				t.Errorf("data = %x, want %x", got.Data, want.Data)
+			}
+		})
+	}
+}
+
+func TestPacketUnmarshalRejects(t *testing.T) {
+	valid := (&Packet{Type: DATA, ClientID: 7, Data: []byte("payload")}).Marshal()
+	modified := func(modify func(buf []byte) []byte) []byte {
+		return modify(append([]byte{}, valid...))
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"empty", nil, ErrInvalidPacket},
+		{"short header", valid[:HeaderSize-1], ErrInvalidPacket},
+		{"truncated payload", valid[:len(valid)-1], ErrInvalidPacket},
+		{"foreign magic", modified(func(buf []byte) []byte {
+			binary.LittleEndian.PutUint16(buf[0:2], 0x1234)
+			return buf
+		}), ErrInvalidPacket},
+		{"other version", modified(func(buf []byte) []byte {
+			buf[2] = ProtocolVersion + 1
+			return buf
+		}), ErrVersionMismatch},
+		{"oversized data size", modified(func(buf []byte) []byte {
+			binary.LittleEndian.PutUint16(buf[20:22], 0xffff)
+			return buf
+		}), ErrInvalidPacket},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var packet Packet
+			if err := packet.Unmarshal(tt.data); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Unmarshal() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPacketUnmarshalVersionMismatchKeepsPrefix(t *testing.T) {
+	buf := (&Packet{Type: CONNECT, ClientID: 99}).Marshal()
+	buf[2] = ProtocolVersion + 1
+
+	var packet Packet
+	if err := packet.Unmarshal(buf); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("Unmarshal() error = %v, want %v", err, ErrVersionMismatch)
+	}
+	if packet.Type != CONNECT || packet.ClientID != 99 {
+		t.Errorf("prefix = type %d client %d, want type %d client 99", packet.Type, packet.ClientID, CONNECT)
+	}
+	if v := packetVersion(buf); v != ProtocolVersion+1 {
+		t.Errorf("packetVersion() = %d, want %d", v, ProtocolVersion+1)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	config := Config{Checksum: true, AppID: 0x47414d45}
+	valid := config.marshal(&Packet{Type: DATA, ClientID: 7, Ack: 3, Data: []byte("payload")})
+	modified := func(modify func(buf []byte) []byte) []byte {
+		return modify(append([]byte{}, valid...))
+	}
+
+	tests := []struct {
+		name    string
+		config  Config
+		data    []byte
+		wantErr error
+	}{
+		{"valid", config, valid, nil},
+		{"corrupted header", config, modified(func(buf []byte) []byte {
+			buf[10] ^= 0x01 // Ack
+			return buf
+		}), ErrChecksumMismatch},
+		{"corrupted payload", config, modified(func(buf []byte) []byte {
+			buf[HeaderSize] ^= 0x80
+			return buf
+		}), ErrChecksumMismatch},
+		{"corrupted checksum", config, modified(func(buf []byte) []byte {
+			buf[len(buf)-1] ^= 0xff
+			return buf
+		}), ErrChecksumMismatch},
+		{"missing checksum", config, valid[:len(valid)-ChecksumSize], ErrInvalidPacket},
+		{"other application", Config{Checksum: true, AppID: config.AppID + 1}, valid, ErrChecksumMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var packet Packet
+			err := tt.config.unmarshal(&packet, tt.data)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("unmarshal() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (!packet.checksummed || string(packet.Data) != "payload") {
+				t.Errorf("checksummed = %v, data = %q", packet.checksummed, packet.Data)
+			}
+		})
+	}
+}
+
+func TestChecksumOptional(t *testing.T) {
+	// Packets without the flag are decoded, and left for the connection to refuse
+	buf := Config{}.marshal(&Packet{Type: DATA, Data: []byte("x")})
+	var packet Packet
+	if err := (Config{Checksum: true}).unmarshal(&packet, buf); err != nil {
+		t.Fatalf("unmarshal() error = %v", err)
+	}
+	if packet.checksummed {
+		t.Error("packet without checksum reported as checksummed")
+	}
+}
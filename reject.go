@@ -0,0 +1,94 @@
+package rudp
+
+import (
+	"fmt"
+	"net"
+)
+
+// RejectReason describes why a server refused a connection
+type RejectReason byte
+
+const (
+	RejectNone            RejectReason = iota // Connection is accepted
+	RejectServerFull                          // Server reached MaxConnections
+	RejectDenied                              // OnConnectRequest refused the client
+	RejectInvalidToken                        // Connect token was missing, invalid, expired or for another server
+	RejectVersionMismatch                     // Client speaks another protocol version
+	RejectAppMismatch                         // Client belongs to another application, see Config.AppID
+	RejectSessionExpired                      // Session the client tried to resume no longer exists
+)
+
+// RejectCustom is the first reason code free for application use
+const RejectCustom RejectReason = 128
+
+// String returns a readable name for the reason
+func (r RejectReason) String() string {
+	switch r {
+	case RejectNone:
+		return "none"
+	case RejectServerFull:
+		return "server full"
+	case RejectDenied:
+		return "denied"
+	case RejectInvalidToken:
+		return "invalid token"
+	case RejectVersionMismatch:
+		return "version mismatch"
+	case RejectAppMismatch:
+		return "application mismatch"
+	case RejectSessionExpired:
+		return "session expired"
+	default:
+		return fmt.Sprintf("custom(%d)", byte(r))
+	}
+}
+
+// RejectedError is returned by Client.Connect when the server refused the connection
+type RejectedError struct {
+	Reason RejectReason
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("connection rejected: %s", e.Reason)
+}
+
+// rejectReason returns the reason carried by a CONNECT_REJECT
+func rejectReason(packet *Packet) RejectReason {
+	if len(packet.Data) == 0 {
+		return RejectDenied
+	}
+	return RejectReason(packet.Data[0])
+}
+
+// reject tells a client why its connection was refused. It is sent in reply to a
+// CONNECT_RESPONSE with a valid cookie, or to a CONNECT, which is always larger, so
+// it cannot be reflected at spoofed addresses with amplification.
+func (s *Server) reject(clientID uint32, addr *net.UDPAddr, reason RejectReason) {
+	s.sendReject(s.config.marshal(rejectPacket(clientID, reason)), addr)
+}
+
+// rejectVersion tells a client of another protocol version why it cannot connect. Its
+// CONNECT may be smaller than ours, or lack the checksum ours carries, so the reject is
+// only sent if it is no larger than the request it answers.
+func (s *Server) rejectVersion(clientID uint32, addr *net.UDPAddr, requestSize int) {
+	data := s.config.marshal(rejectPacket(clientID, RejectVersionMismatch))
+	if len(data) > requestSize {
+		return
+	}
+	s.sendReject(data, addr)
+}
+
+// rejectPacket builds the CONNECT_REJECT carrying a reason
+func rejectPacket(clientID uint32, reason RejectReason) *Packet {
+	return &Packet{
+		Type:     CONNECT_REJECT,
+		ClientID: clientID,
+		Data:     []byte{byte(reason)},
+	}
+}
+
+// sendReject sends a serialized CONNECT_REJECT and counts it
+func (s *Server) sendReject(data []byte, addr *net.UDPAddr) {
+	s.conn.WriteToUDP(data, addr)
+	s.rejected.Add(1)
+}
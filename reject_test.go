@@ -0,0 +1,142 @@
+package rudp
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnectRejected(t *testing.T) {
+	tests := []struct {
+		name         string
+		serverConfig Config
+		clientConfig Config
+		hook         RejectReason // Returned by OnConnectRequest
+		want         RejectReason
+	}{
+		{"denied by the hook", Config{}, Config{}, RejectDenied, RejectDenied},
+		{"custom reason", Config{}, Config{}, RejectCustom + 3, RejectCustom + 3},
+		{"other application", Config{AppID: 1}, Config{AppID: 2}, RejectNone, RejectAppMismatch},
+		{"server full", Config{MaxConnections: 1}, Config{}, RejectNone, RejectServerFull},
+		{"token required", Config{TokenSecret: make([]byte, 32), PublicAddr: "127.0.0.1:1"}, Config{Secure: true}, RejectNone, RejectInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewServerWithConfig(tt.serverConfig)
+			if err != nil {
+				t.Fatalf("NewServerWithConfig() error = %v", err)
+			}
+			s.OnConnectRequest = func(*net.UDPAddr, uint32, []byte) ([]byte, RejectReason) {
+				return nil, tt.hook
+			}
+			addr := listenTestServer(t, s)
+
+			clientConfig := tt.clientConfig
+			clientConfig.HandshakeTimeout = 2 * time.Second
+			if tt.want == RejectServerFull {
+				first, _ := NewClientWithConfig(clientConfig)
+				if err := first.Connect(addr); err != nil {
+					t.Fatalf("first Connect() error = %v", err)
+				}
+				defer first.Close()
+			}
+
+			client, err := NewClientWithConfig(clientConfig)
+			if err != nil {
+				t.Fatalf("NewClientWithConfig() error = %v", err)
+			}
+			err = client.Connect(addr)
+			var rejected *RejectedError
+			if !errors.As(err, &rejected) {
+				t.Fatalf("Connect() error = %v, want a *RejectedError", err)
+			}
+			if rejected.Reason != tt.want {
+				t.Errorf("rejected with %v, want %v", rejected.Reason, tt.want)
+			}
+			if stats := s.Stats(); stats.HandshakesRejected != 1 {
+				t.Errorf("HandshakesRejected = %d, want 1", stats.HandshakesRejected)
+			}
+		})
+	}
+}
+
+func TestRejectReason(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want RejectReason
+	}{
+		{nil, RejectDenied},
+		{[]byte{byte(RejectServerFull)}, RejectServerFull},
+		{[]byte{byte(RejectCustom)}, RejectCustom},
+	}
+	for _, tt := range tests {
+		if got := rejectReason(&Packet{Type: CONNECT_REJECT, Data: tt.data}); got != tt.want {
+			t.Errorf("rejectReason(%v) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+
+	err := error(&RejectedError{Reason: RejectCustom + 1})
+	if got := err.Error(); got != "connection rejected: custom(129)" {
+		t.Errorf("Error() = %q", got)
+	}
+}
+
+func TestRejectVersionMismatch(t *testing.T) {
+	// A CONNECT of another version, as small as any version may send
+	otherVersion := func(padding int) []byte {
+		data := (&Packet{Type: CONNECT, ClientID: 7, Data: make([]byte, padding)}).Marshal()
+		data[2] = ProtocolVersion + 1
+		return data
+	}
+
+	tests := []struct {
+		name     string
+		checksum bool
+		request  []byte
+		want     bool
+	}{
+		{"padded", false, otherVersion(connectPadding), true},
+		{"padded, with checksums", true, otherVersion(connectPadding), true},
+		{"header only", false, otherVersion(0), false},
+		{"smaller than the checksummed reject", true, otherVersion(ChecksumSize), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewServerWithConfig(Config{Checksum: tt.checksum})
+			if err != nil {
+				t.Fatalf("NewServerWithConfig() error = %v", err)
+			}
+			listenTestServer(t, s)
+
+			client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatalf("ListenUDP() error = %v", err)
+			}
+			defer client.Close()
+
+			if err := s.handlePacket(tt.request, client.LocalAddr().(*net.UDPAddr)); !errors.Is(err, ErrVersionMismatch) {
+				t.Fatalf("handlePacket() error = %v, want %v", err, ErrVersionMismatch)
+			}
+
+			buf := make([]byte, MaxUDPPayloadSize)
+			client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, _, err := client.ReadFromUDP(buf)
+			if sent := err == nil; sent != tt.want {
+				t.Fatalf("reject sent = %v, want %v", sent, tt.want)
+			}
+			if !tt.want {
+				return
+			}
+			if n > len(tt.request) {
+				t.Errorf("reject of %d bytes is larger than the %d byte request", n, len(tt.request))
+			}
+			packet := &Packet{}
+			if err := s.config.unmarshal(packet, buf[:n]); err != nil || rejectReason(packet) != RejectVersionMismatch {
+				t.Errorf("sent %v (%v), want a CONNECT_REJECT with %v", packet, err, RejectVersionMismatch)
+			}
+		})
+	}
+}
@@ -1,19 +1,24 @@
 package rudp
 
 import (
+	"encoding/binary"
+	"errors"
+	"math"
 	"time"
 )
 
-const (
-	RetransmissionTimeout = 100 * time.Millisecond
-	MaxRetransmissions    = 5
-)
+const ackDelaySize = 4 // Microseconds a standalone ACK was held, carried as its payload
 
-// processOutbound handles sending queued packets
+// processOutbound handles sending queued packets, paced by the send rate limits
 func (c *Connection) processOutbound() {
 	for {
 		select {
 		case packet := <-c.outbound:
+			size := c.wireSize(packet)
+			c.queuedBytes.Add(-int64(size))
+			if !c.pace(size) {
+				return
+			}
 			c.sendPacket(packet)
 		case <-c.done:
 			return
@@ -23,7 +28,12 @@ func (c *Connection) processOutbound() {
 
 // processRetransmissions handles reliable packet retransmission
 func (c *Connection) processRetransmissions() {
-	ticker := time.NewTicker(RetransmissionTimeout)
+	// Check often enough to honour the smallest timeout the estimator can produce
+	interval := c.config.MinRetransmissionTimeout / 2
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
@@ -39,52 +49,197 @@ func (c *Connection) processRetransmissions() {
 // sendPacket transmits a packet over the wire
 func (c *Connection) sendPacket(packet *Packet) {
 	c.mu.Lock()
+	packet.queued = false
 	packet.LastSent = time.Now()
 	packet.Attempts++
 	c.lastSent = packet.LastSent
+	addr := c.addr
 	c.mu.Unlock()
 
-	data := packet.Marshal()
-	c.conn.WriteToUDP(data, c.addr)
+	if packet.IsReliable() {
+		if packet.Attempts == 1 {
+			c.counters.reliableSent.Add(1)
+		} else {
+			c.counters.retransmissions.Add(1)
+		}
+	}
+
+	data := c.encode(packet)
+	if _, err := c.conn.WriteToUDP(data, addr); err == nil {
+		c.counters.packetsSent.Add(1)
+		c.counters.bytesSent.Add(uint64(len(data)))
+	}
 }
 
-// checkRetransmissions resends reliable packets that haven't been acknowledged
+// checkRetransmissions resends reliable packets that haven't been acknowledged.
+// Resumable connections are suspended rather than giving up on a packet, and
+// retransmit nothing until they are resumed.
 func (c *Connection) checkRetransmissions() {
+	var failed []*Packet
+	var suspend bool
+	defer func() {
+		c.notifyDeliveryFailed(failed)
+		if suspend {
+			c.suspend()
+		}
+	}()
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.suspended {
+		return
+	}
+
 	now := time.Now()
 	for seq, packet := range c.pendingAcks {
-		if now.Sub(packet.LastSent) > RetransmissionTimeout {
-			if packet.Attempts >= MaxRetransmissions {
-				delete(c.pendingAcks, seq)
+		if _, exists := c.pendingAcks[seq]; !exists {
+			// Given up on along with another fragment of its message
+			continue
+		}
+		if packet.queued {
+			// Still waiting for its turn to be sent
+			continue
+		}
+		if now.Sub(packet.LastSent) > c.rtt.timeout(packet.Attempts) {
+			if packet.Attempts >= c.config.MaxRetransmissions {
+				if c.resumeKey != nil {
+					suspend = true
+					return
+				}
+				messages := c.failPacket(packet)
+				c.counters.deliveryFailures.Add(uint64(len(messages)))
+				failed = append(failed, messages...)
 				continue
 			}
-
-			select {
-			case c.outbound <- packet:
-			default:
-				// Buffer full, skip this round
+			if packet.Attempts > 0 {
+				c.congestion.OnLoss(packet.LastSent)
 			}
+
+			// If the buffer is full, skip this round
+			c.enqueue(packet)
 		}
 	}
+	c.admitBacklog()
+	failed = append(failed, c.checkMTU(now)...)
 }
 
 // HandleIncomingPacket processes received packets
 func (c *Connection) HandleIncomingPacket(packet *Packet) error {
+	if err := c.authenticate(packet); err != nil {
+		return err
+	}
+	c.counters.packetsReceived.Add(1)
+	c.counters.bytesReceived.Add(uint64(c.wireSize(packet)))
+
+	// Anything the peer sends ends a suspension
+	if c.IsSuspended() {
+		c.resume()
+	}
+
+	switch packet.Type {
+	case DATA, FRAGMENT, BATCH:
+	case ORDER_SKIP:
+		if packet.Mode != ReliableOrdered {
+			return ErrInvalidPacket
+		}
+	case DISCONNECT:
+		c.handleDisconnect(packet)
+		return nil
+	case PING, PONG:
+		c.handleHeartbeat(packet)
+		return nil
+	case ACK:
+		c.handleAck(packet)
+		return nil
+	case PATH_CHALLENGE:
+		c.handlePathChallenge(packet)
+		return nil
+	case PATH_RESPONSE:
+		// Answers arriving from the current address have nothing left to validate
+		return nil
+	case MTU_PROBE:
+		c.handleMTUProbe(packet)
+		return nil
+	case MTU_PROBE_ACK:
+		c.handleMTUProbeAck(packet)
+		return nil
+	default:
+		return ErrInvalidPacket
+	}
+
+	if int(packet.Channel) >= c.config.Channels {
+		return ErrInvalidChannel
+	}
+
+	// Refuse malformed batches before acking them
+	var messages []*Packet
+	if packet.Type == BATCH {
+		var err error
+		if messages, err = c.unbatch(packet); err != nil {
+			return err
+		}
+	}
+
 	c.mu.Lock()
-	c.lastReceived = time.Now()
+	now := time.Now()
+	c.lastReceived = now
 
 	// Process acknowledgments
 	c.processAcknowledgments(packet.Ack, packet.AckBits)
 
+	// Refuse fragments that cannot be reassembled and ordered packets too far ahead of
+	// their stream before acking them, so they are retransmitted
+	if packet.Type == FRAGMENT {
+		if err := c.checkFragment(packet, now); err != nil {
+			if errors.Is(err, ErrReassemblyFull) {
+				c.counters.reassemblyFull.Add(1)
+			}
+			c.mu.Unlock()
+			return err
+		}
+	}
+	for _, message := range append(messages, packet) {
+		if err := c.checkOrder(message); err != nil {
+			c.counters.orderWindowFull.Add(1)
+			c.mu.Unlock()
+			return err
+		}
+	}
+
 	// Update remote sequence tracking
-	if sequenceGreater(packet.Sequence, c.remoteSequence) {
-		c.updateAckBits(packet.Sequence)
-		c.remoteSequence = packet.Sequence
+	duplicate := c.recordReceived(packet.Sequence)
+	if duplicate {
+		c.counters.duplicates.Add(1)
+	}
+
+	// Reliable packets must be acked even if they are duplicates, since the
+	// duplicate means our previous ack was lost
+	if packet.IsReliable() {
+		if duplicate && c.remoteSequence-packet.Sequence > 32 {
+			// The ack bits no longer cover it, so ack it on its own
+			c.queueAckLocked(packet.Sequence)
+		} else {
+			c.scheduleAck()
+		}
+	}
+
+	if !duplicate && packet.Type == FRAGMENT {
+		packet = c.reassemble(packet, now)
 	}
 	c.mu.Unlock()
 
+	if duplicate || packet == nil {
+		return nil
+	}
+
+	if packet.Type == BATCH {
+		for _, message := range messages {
+			c.handlePacketDelivery(message)
+		}
+		return nil
+	}
+
 	// Handle packet based on delivery mode
 	c.handlePacketDelivery(packet)
 
@@ -93,28 +248,183 @@ func (c *Connection) HandleIncomingPacket(packet *Packet) error {
 
 // processAcknowledgments removes acknowledged packets from pending list
 func (c *Connection) processAcknowledgments(ack uint16, ackBits uint32) {
+	now := time.Now()
+	c.processAcks(ack, ackBits, now, now)
+}
+
+// processDelayedAck handles the acks of a standalone ACK. The peer held the newest
+// packet for delay before acking it, which is taken off its round-trip sample. The
+// packets acked by the bits were held for an unknown time, so they are not sampled.
+func (c *Connection) processDelayedAck(ack uint16, ackBits uint32, delay time.Duration) {
+	c.processAcks(ack, ackBits, time.Now().Add(-delay), time.Time{})
+}
+
+// processAcks acknowledges ack as received by the peer at ackedAt and the sequences
+// in ackBits at bitsAckedAt
+func (c *Connection) processAcks(ack uint16, ackBits uint32, ackedAt, bitsAckedAt time.Time) {
 	// Acknowledge the explicit ack
-	delete(c.pendingAcks, ack)
+	c.acknowledge(ack, ackedAt)
 
 	// Process ack bits for previous packets
 	for i := uint32(0); i < 32; i++ {
 		if (ackBits & (1 << i)) != 0 {
 			seq := ack - uint16(i+1)
-			delete(c.pendingAcks, seq)
+			c.acknowledge(seq, bitsAckedAt)
 		}
 	}
+	c.admitBacklog()
 }
 
-// updateAckBits updates the acknowledgment bitfield
+// acknowledge removes a packet from the pending list and samples its round-trip time
+// up to ackedAt, unless that is zero. Retransmitted packets are not sampled since the
+// ack may be for any of their copies.
+func (c *Connection) acknowledge(seq uint16, ackedAt time.Time) {
+	packet, exists := c.pendingAcks[seq]
+	if !exists {
+		return
+	}
+	delete(c.pendingAcks, seq)
+
+	packet.resolve(DeliveryDelivered)
+
+	if packet.Attempts == 1 && !ackedAt.IsZero() {
+		c.rtt.update(ackedAt.Sub(packet.LastSent))
+	}
+	c.congestion.OnAck(packet.LastSent)
+}
+
+// recordReceived marks a sequence as received and reports whether it had already
+// been received. Sequences too old for the history are reported as duplicates
+// since they can no longer be told apart.
+func (c *Connection) recordReceived(seq uint16) bool {
+	if !c.receivedAny {
+		c.receivedAny = true
+		c.remoteSequence = seq
+		c.remoteReceivedAt = time.Now()
+		c.ackBits = 0
+		c.received.insert(seq)
+		return false
+	}
+
+	if sequenceGreater(seq, c.remoteSequence) {
+		// Ack now if moving the window would push unacked sequences out of the ack bits
+		if c.ackPending && seq-c.ackPendingFrom >= 32 {
+			c.queueControlLocked(ACK, c.ackDelayData())
+		}
+		c.received.advance(c.remoteSequence, seq)
+		c.received.insert(seq)
+		c.updateAckBits(seq)
+		c.remoteSequence = seq
+		c.remoteReceivedAt = time.Now()
+		return false
+	}
+
+	diff := c.remoteSequence - seq
+	if diff >= receivedHistorySize || c.received.has(seq) {
+		return true
+	}
+
+	c.received.insert(seq)
+	c.counters.outOfOrder.Add(1)
+	if diff <= 32 {
+		c.ackBits |= 1 << (diff - 1)
+	}
+	return false
+}
+
+// updateAckBits shifts the acknowledgment bitfield for a new most recent sequence,
+// keeping the previous most recent sequence marked as received
 func (c *Connection) updateAckBits(newSeq uint16) {
 	diff := newSeq - c.remoteSequence
 	if diff > 32 {
 		c.ackBits = 0
+	} else if diff == 32 {
+		c.ackBits = 1 << 31
+	} else {
+		c.ackBits = (c.ackBits << diff) | (1 << (diff - 1))
+	}
+}
+
+// scheduleAck arranges for a standalone ACK to be sent if no outgoing packet
+// carries the current acks within AckDelay. Must be called with the lock held.
+func (c *Connection) scheduleAck() {
+	if c.ackPending {
+		return
+	}
+	c.ackPending = true
+	c.ackPendingFrom = c.remoteSequence
+
+	if c.ackTimer == nil {
+		c.ackTimer = time.AfterFunc(c.config.AckDelay, c.flushAck)
 	} else {
-		c.ackBits = (c.ackBits << diff) | 1
+		c.ackTimer.Reset(c.config.AckDelay)
+	}
+}
+
+// flushAck sends a standalone ACK if the current acks have not gone out with another packet
+func (c *Connection) flushAck() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ackPending {
+		c.queueControlLocked(ACK, c.ackDelayData())
 	}
 }
 
+// ackDelayData encodes how long the newest received packet has waited for its ack as
+// the payload of a standalone ACK, so the peer can take it off its round-trip sample.
+// Must be called with the lock held.
+func (c *Connection) ackDelayData() []byte {
+	delay := min(time.Since(c.remoteReceivedAt).Microseconds(), math.MaxUint32)
+	return binary.LittleEndian.AppendUint32(nil, uint32(delay))
+}
+
+// parseAckDelay decodes the delay reported by a standalone ACK, which is zero for
+// ACKs sent at once
+func parseAckDelay(data []byte) time.Duration {
+	if len(data) < ackDelaySize {
+		return 0
+	}
+	return time.Duration(binary.LittleEndian.Uint32(data)) * time.Microsecond
+}
+
+// queueAckLocked queues a standalone ACK for a sequence older than the ack bits
+// cover, with the bits filled in from the received history. Must be called with
+// the lock held.
+func (c *Connection) queueAckLocked(seq uint16) {
+	packet := &Packet{
+		Type:      ACK,
+		ClientID:  c.clientID,
+		Ack:       seq,
+		AckBits:   c.received.ackBits(seq),
+		Timestamp: time.Now().UnixNano(),
+	}
+
+	// If the buffer is full, the next retransmission will ask again
+	c.enqueue(packet)
+}
+
+// authenticate drops packets lacking a required checksum and, on secure connections,
+// anything not sealed by the peer before it can affect any state
+func (c *Connection) authenticate(packet *Packet) error {
+	if c.config.Checksum && !packet.checksummed {
+		return ErrChecksumMismatch
+	}
+	if c.session != nil {
+		return c.session.open(packet)
+	}
+	return nil
+}
+
+// handleAck processes a standalone ACK
+func (c *Connection) handleAck(packet *Packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastReceived = time.Now()
+	c.processDelayedAck(packet.Ack, packet.AckBits, parseAckDelay(packet.Data))
+}
+
 // handlePacketDelivery processes packet based on delivery guarantees
 func (c *Connection) handlePacketDelivery(packet *Packet) {
 	if packet.IsOrdered() {
@@ -124,21 +434,21 @@ func (c *Connection) handlePacketDelivery(packet *Packet) {
 	}
 }
 
-// handleOrderedDelivery ensures packets are delivered in sequence
+// handleOrderedDelivery ensures packets are delivered in the order of their stream
 func (c *Connection) handleOrderedDelivery(packet *Packet) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	c.orderedBuffer[packet.Sequence] = packet
+	stream := c.stream(packet.Channel, packet.Mode)
+	if sequenceGreater(stream.nextRecv, packet.OrderSequence) {
+		// Too late, the stream already moved past it
+		c.counters.lateOrdered.Add(1)
+	}
+	ready := stream.push(packet, time.Now())
+	c.mu.Unlock()
 
 	// Deliver consecutive packets
-	for {
-		if p, exists := c.orderedBuffer[c.remoteSequence+1]; exists {
-			delete(c.orderedBuffer, c.remoteSequence+1)
-			c.remoteSequence++
-			go c.deliverPacket(p)
-		} else {
-			break
+	for _, p := range ready {
+		if p.Type != ORDER_SKIP {
+			c.deliverPacket(p)
 		}
 	}
 }
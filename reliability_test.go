@@ -0,0 +1,180 @@
+package rudp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordReceived(t *testing.T) {
+	tests := []struct {
+		name          string
+		received      []uint16
+		wantRemote    uint16
+		wantAckBits   uint32
+		wantDuplicate bool // Of the last sequence
+	}{
+		{"first", []uint16{5}, 5, 0, false},
+		{"consecutive", []uint16{0, 1, 2}, 2, 0b11, false},
+		{"gap", []uint16{0, 3}, 3, 0b100, false},
+		{"gap filled late", []uint16{0, 3, 2}, 3, 0b101, false},
+		{"duplicate", []uint16{1, 1}, 1, 0, true},
+		{"duplicate of an older one", []uint16{0, 1, 2, 0}, 2, 0b11, true},
+		{"wraparound", []uint16{65534, 65535, 0, 1}, 1, 0b111, false},
+		{"wraparound reordered", []uint16{65535, 1, 0}, 1, 0b11, false},
+		{"older across wraparound", []uint16{65530, 3, 65531}, 3, 1<<8 | 1<<7, false},
+		{"jump of 32", []uint16{0, 32}, 32, 1 << 31, false},
+		{"jump beyond the ack bits", []uint16{0, 40}, 40, 0, false},
+		{"older than the ack bits", []uint16{0, 40, 5}, 40, 0, false},
+		{"duplicate older than the ack bits", []uint16{0, 40, 5, 5}, 40, 0, true},
+		{"older than the history", []uint16{0, receivedHistorySize + 1, 1}, receivedHistorySize + 1, 0, true},
+		{"stale slot after wraparound", []uint16{0, 30000, 60000, 64}, 64, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConnection(DefaultConfig())
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			var duplicate bool
+			for _, seq := range tt.received {
+				duplicate = c.recordReceived(seq)
+			}
+			if c.remoteSequence != tt.wantRemote || c.ackBits != tt.wantAckBits {
+				t.Errorf("remoteSequence %d, ackBits %b, want %d and %b", c.remoteSequence, c.ackBits, tt.wantRemote, tt.wantAckBits)
+			}
+			if duplicate != tt.wantDuplicate {
+				t.Errorf("last sequence duplicate = %v, want %v", duplicate, tt.wantDuplicate)
+			}
+		})
+	}
+}
+
+func TestUpdateAckBits(t *testing.T) {
+	tests := []struct {
+		name    string
+		remote  uint16
+		ackBits uint32
+		newSeq  uint16
+		want    uint32
+	}{
+		{"next", 10, 0b1, 11, 0b11},
+		{"skip one", 10, 0b1, 12, 0b110},
+		{"across wraparound", 65535, 0b1, 1, 0b110},
+		{"oldest bit shifted out", 10, 1 << 31, 11, 0b1},
+		{"by 31", 10, 0b1, 41, 1<<30 | 1<<31},
+		{"by 32", 10, 0b1, 42, 1 << 31},
+		{"by more than 32", 10, 0b1, 43, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Connection{remoteSequence: tt.remote, ackBits: tt.ackBits}
+			c.updateAckBits(tt.newSeq)
+			if c.ackBits != tt.want {
+				t.Errorf("ackBits = %b, want %b", c.ackBits, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessAcknowledgmentsWraparound(t *testing.T) {
+	c := newTestConnection(DefaultConfig())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.localSequence = 65534
+	for i := 0; i < 4; i++ {
+		c.admit(&Packet{Type: DATA, Mode: Reliable}) // 65534, 65535, 0 and 1
+	}
+
+	// Acks 1, 0 and 65534 but not 65535
+	c.processAcknowledgments(1, 0b101)
+	if len(c.pendingAcks) != 1 || c.pendingAcks[65535] == nil {
+		t.Errorf("pending %v, want only 65535", c.pendingAcks)
+	}
+
+	// The oldest ack bit reaches back 32 sequences, across the wraparound
+	c.processAcknowledgments(31, 1<<31)
+	if len(c.pendingAcks) != 0 {
+		t.Errorf("pending %v after ack bit 31 of 31, want none", c.pendingAcks)
+	}
+}
+
+func TestAckBeyondAckBits(t *testing.T) {
+	c := newTestConnection(DefaultConfig())
+	for _, seq := range []uint16{0, 40} {
+		if err := c.HandleIncomingPacket(&Packet{Type: DATA, Sequence: seq, Mode: Reliable, Ack: ^uint16(0)}); err != nil {
+			t.Fatalf("HandleIncomingPacket() error = %v", err)
+		}
+	}
+	c.ackTimer.Stop()
+	drainOutbound(c)
+
+	// The resend of 0 means its ack was lost, and the ack bits of 40 no longer cover it
+	if err := c.HandleIncomingPacket(&Packet{Type: DATA, Sequence: 0, Mode: Reliable, Ack: ^uint16(0)}); err != nil {
+		t.Fatalf("HandleIncomingPacket() error = %v", err)
+	}
+	sent := drainOutbound(c)
+	if len(sent) != 1 || sent[0].Type != ACK || sent[0].Ack != 0 {
+		t.Fatalf("sent %v, want an ACK of sequence 0", sent)
+	}
+}
+
+func TestDelayedAck(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	reliable := func(seq uint16) *Packet {
+		return &Packet{Type: DATA, Sequence: seq, Mode: Reliable, Ack: ^uint16(0)}
+	}
+
+	tests := []struct {
+		name        string
+		receive     []*Packet
+		reply       bool // Whether an outgoing packet carries the acks before the delay ends
+		wantAtOnce  bool // Whether a standalone ACK is sent without waiting for the delay
+		wantDelayed bool // Whether a standalone ACK is sent after the delay
+	}{
+		{"reliable", []*Packet{reliable(0)}, false, false, true},
+		{"unreliable is not acked", []*Packet{{Type: DATA, Sequence: 0, Mode: Unreliable, Ack: ^uint16(0)}}, false, false, false},
+		{"acks carried by outgoing traffic", []*Packet{reliable(0)}, true, false, false},
+		{"window moving past unacked sequences", []*Packet{reliable(0), {Type: DATA, Sequence: 32, Mode: Unreliable, Ack: ^uint16(0)}}, false, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.AckDelay = delay
+			c := newTestConnection(config)
+			defer c.shutdown(DisconnectNone, nil)
+
+			for _, packet := range tt.receive {
+				if err := c.HandleIncomingPacket(packet); err != nil {
+					t.Fatalf("HandleIncomingPacket() error = %v", err)
+				}
+			}
+			if tt.reply {
+				if err := c.Send([]byte("reply"), Unreliable); err != nil {
+					t.Fatalf("Send() error = %v", err)
+				}
+			}
+			if acked := hasAck(drainOutbound(c)); acked != tt.wantAtOnce {
+				t.Errorf("ACK sent at once = %v, want %v", acked, tt.wantAtOnce)
+			}
+
+			time.Sleep(5 * delay)
+			if acked := hasAck(drainOutbound(c)); acked != tt.wantDelayed {
+				t.Errorf("ACK sent after the delay = %v, want %v", acked, tt.wantDelayed)
+			}
+		})
+	}
+}
+
+// hasAck reports whether packets include a standalone ACK
+func hasAck(packets []*Packet) bool {
+	for _, packet := range packets {
+		if packet.Type == ACK {
+			return true
+		}
+	}
+	return false
+}
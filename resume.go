@@ -0,0 +1,188 @@
+package rudp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"net"
+	"time"
+)
+
+// When Config.ResumeGracePeriod is set, a connection whose peer goes silent is
+// suspended rather than closed: its reliable packets, sequence numbers and ordering
+// state are kept, nothing is given up on, and it is only closed if it is not resumed
+// within the grace period. A client reconnecting with the same ClientID proves it holds
+// the resume key derived from the connection token of CONNECT_ACK and takes the
+// connection over, even from a new address.
+
+// resumeProof computes the proof of the resume key sent in CONNECT_RESPONSE. It is
+// bound to the cookie of the handshake, so it cannot be replayed.
+func resumeProof(key []byte, cookie []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(cookie)
+	return mac.Sum(nil)[:cookieMACSize]
+}
+
+// suspend marks a resumable connection as timed out, starting its grace period
+func (c *Connection) suspend() {
+	c.mu.Lock()
+	if c.closed || c.suspended {
+		c.mu.Unlock()
+		return
+	}
+	c.suspended = true
+	c.suspendedAt = time.Now()
+	onSuspend := c.onSuspend
+	c.mu.Unlock()
+
+	if onSuspend != nil {
+		onSuspend()
+	}
+}
+
+// resume ends a suspension, resending pending reliable packets right away with
+// fresh attempts. Path MTU discovery starts over, since the peer may be back on
+// another network, revalidating the current MTU before trusting it again.
+// It returns false if the connection was already closed.
+func (c *Connection) resume() bool {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return false
+	}
+	c.lastReceived = time.Now()
+	if !c.suspended {
+		c.mu.Unlock()
+		return true
+	}
+	c.suspended = false
+	c.restartMTUDiscovery()
+	for _, packet := range c.pendingAcks {
+		packet.Attempts = 0
+		packet.LastSent = time.Time{}
+	}
+	onResume := c.onResume
+	c.mu.Unlock()
+
+	if onResume != nil {
+		onResume()
+	}
+	return true
+}
+
+// expired reports whether the connection timed out and can no longer be resumed
+func (c *Connection) expired() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	switch {
+	case c.closed:
+		return true
+	case c.suspended:
+		return time.Since(c.suspendedAt) >= c.config.ResumeGracePeriod
+	default:
+		return c.resumeKey == nil && time.Since(c.lastReceived) >= c.config.InactivityTimeout
+	}
+}
+
+// resumeSession lets a client that proved it holds the resume key of its connection
+// take it over, possibly from a new address. A connection that moved within the last
+// MigrationInterval is not taken over; the client tries again after backing off.
+func (s *Server) resumeSession(conn *Connection, clientID uint32, response *connectResponse, addr *net.UDPAddr) (bool, error) {
+	if conn == nil || conn.resumeKey == nil {
+		s.reject(clientID, addr, RejectSessionExpired)
+		return false, nil
+	}
+	if !hmac.Equal(response.resume, resumeProof(conn.resumeKey, response.cookie)) {
+		return false, ErrInvalidProof
+	}
+
+	// Resuming from a new address moves the connection, which is limited like any other move
+	moving := conn.RemoteAddr().String() != addr.String()
+	if moving && !conn.mayMigrate(time.Now()) {
+		return false, ErrMigrationTooSoon
+	}
+
+	if !conn.resume() {
+		s.reject(clientID, addr, RejectSessionExpired)
+		return false, nil
+	}
+	if moving {
+		s.migrate(conn, addr)
+	}
+	return true, nil
+}
+
+// reconnect tries to resume a suspended connection, backing off exponentially between
+// attempts, until it is resumed, the server rejects it, or the grace period runs out
+func (c *Client) reconnect() {
+	if !c.reconnecting.CompareAndSwap(false, true) {
+		return
+	}
+	defer c.reconnecting.Store(false)
+
+	backoff := c.config.ReconnectBackoff
+	for attempt := 1; c.connection.IsSuspended(); attempt++ {
+		if c.OnReconnecting != nil {
+			c.OnReconnecting(attempt)
+		}
+
+		err := c.attemptResume(backoff)
+		var rejected *RejectedError
+		switch {
+		case err == nil, errors.Is(err, ErrConnectionClosed):
+			return
+		case errors.As(err, &rejected):
+			c.connection.shutdown(DisconnectTimeout, nil)
+			return
+		}
+
+		backoff = min(2*backoff, c.config.MaxReconnectBackoff)
+	}
+}
+
+// attemptResume runs one resuming handshake, waiting up to the given time for it to
+// complete. Replies are passed on by handlePackets, which owns the socket.
+func (c *Client) attemptResume(wait time.Duration) error {
+	// Forget replies to earlier attempts
+	for len(c.handshakeReplies) > 0 {
+		<-c.handshakeReplies
+	}
+
+	// Send errors while the network is down are treated like lost packets
+	addr := c.connection.RemoteAddr()
+	c.conn.WriteToUDP(c.config.marshal(c.connectRequest()), addr)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case packet := <-c.handshakeReplies:
+			switch packet.Type {
+			case CONNECT_CHALLENGE:
+				response := &connectResponse{
+					cookie: packet.Data,
+					resume: resumeProof(c.connection.resumeKey, packet.Data),
+				}
+				request := &Packet{
+					Type:     CONNECT_RESPONSE,
+					ClientID: c.clientID,
+					Data:     response.marshal(),
+				}
+				c.conn.WriteToUDP(c.config.marshal(request), addr)
+			case CONNECT_REJECT:
+				return &RejectedError{Reason: rejectReason(packet)}
+			case CONNECT_ACK:
+				if !c.connection.resume() {
+					return ErrConnectionClosed
+				}
+				return nil
+			}
+		case <-timer.C:
+			return ErrTimeout
+		case <-c.connection.done:
+			return ErrConnectionClosed
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package rudp
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// newResumableTestConnection creates a connection that can be resumed with its resume key
+func newResumableTestConnection(addr *net.UDPAddr) *Connection {
+	config := DefaultConfig()
+	config.ResumeGracePeriod = time.Minute
+	c := newConnection(nil, addr, 1, config)
+	c.resumeKey = make([]byte, 32)
+	return c
+}
+
+func TestResume(t *testing.T) {
+	tests := []struct {
+		name      string
+		suspended bool
+		closed    bool
+		want      bool
+	}{
+		{"suspended", true, false, true},
+		{"not suspended", false, false, true},
+		{"closed", true, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newResumableTestConnection(nil)
+			c.mu.Lock()
+			c.admit(&Packet{Type: DATA, Mode: Reliable, Data: []byte("queued")})
+			c.mu.Unlock()
+			packet := <-c.outbound
+			packet.Attempts = DefaultMaxRetransmissions
+			packet.LastSent = time.Now()
+
+			if tt.suspended {
+				c.suspend()
+			}
+			if tt.closed {
+				c.shutdown(DisconnectTimeout, nil)
+			}
+
+			resumed := 0
+			c.onResume = func() { resumed++ }
+			if got := c.resume(); got != tt.want {
+				t.Fatalf("resume() = %v, want %v", got, tt.want)
+			}
+			if c.IsSuspended() && !tt.closed {
+				t.Error("connection still suspended after resuming")
+			}
+
+			// Only ending a suspension resends pending packets with fresh attempts
+			wantResumed := 0
+			if tt.suspended && !tt.closed {
+				wantResumed = 1
+				if packet.Attempts != 0 || !packet.LastSent.IsZero() {
+					t.Errorf("pending packet kept %d attempts, want them reset", packet.Attempts)
+				}
+			}
+			if resumed != wantResumed {
+				t.Errorf("onResume called %d times, want %d", resumed, wantResumed)
+			}
+		})
+	}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name         string
+		resumable    bool
+		closed       bool
+		suspendedAt  time.Time // Zero if not suspended
+		lastReceived time.Time
+		want         bool
+	}{
+		{"live", false, false, time.Time{}, now, false},
+		{"inactive", false, false, time.Time{}, now.Add(-2 * DefaultInactivityTimeout), true},
+		{"inactive but resumable", true, false, time.Time{}, now.Add(-2 * DefaultInactivityTimeout), false},
+		{"suspended within grace period", true, false, now.Add(-time.Second), now.Add(-time.Hour), false},
+		{"suspended past grace period", true, false, now.Add(-2 * time.Minute), now.Add(-time.Hour), true},
+		{"closed", true, true, time.Time{}, now, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newResumableTestConnection(nil)
+			if !tt.resumable {
+				c.resumeKey = nil
+			}
+			c.closed = tt.closed
+			c.suspended = !tt.suspendedAt.IsZero()
+			c.suspendedAt = tt.suspendedAt
+			c.lastReceived = tt.lastReceived
+
+			if got := c.expired(); got != tt.want {
+				t.Errorf("expired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResumeSessionMigrationInterval(t *testing.T) {
+	oldAddr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 9000}
+	newAddr := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 9000}
+	otherAddr := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 9000}
+
+	s := &Server{}
+	moves := 0
+	s.OnAddressChanged = func(*Connection, *net.UDPAddr, *net.UDPAddr) { moves++ }
+	c := newResumableTestConnection(oldAddr)
+
+	resumeFrom := func(addr *net.UDPAddr) (bool, error) {
+		cookie := newCookie(newCookieSecret(), addr, 1, time.Now())
+		response := &connectResponse{cookie: cookie, resume: resumeProof(c.resumeKey, cookie)}
+		return s.resumeSession(c, 1, response, addr)
+	}
+
+	// A live connection may be taken over from a new address once
+	if ok, err := resumeFrom(newAddr); !ok || err != nil {
+		t.Fatalf("resumeSession() = %v, %v, want the connection moved", ok, err)
+	}
+	if c.RemoteAddr() != newAddr || moves != 1 {
+		t.Fatalf("connection at %v after %d moves, want %v", c.RemoteAddr(), moves, newAddr)
+	}
+
+	// Not again within MigrationInterval, while resuming from where it is stays allowed
+	if ok, err := resumeFrom(otherAddr); ok || !errors.Is(err, ErrMigrationTooSoon) {
+		t.Errorf("resumeSession() from another address = %v, %v, want %v", ok, err, ErrMigrationTooSoon)
+	}
+	if ok, err := resumeFrom(newAddr); !ok || err != nil {
+		t.Errorf("resumeSession() from the current address = %v, %v, want it resumed", ok, err)
+	}
+	if c.RemoteAddr() != newAddr || moves != 1 {
+		t.Errorf("connection at %v after %d moves, want %v", c.RemoteAddr(), moves, newAddr)
+	}
+
+	// A wrong proof is refused before anything changes
+	cookie := newCookie(newCookieSecret(), otherAddr, 1, time.Now())
+	response := &connectResponse{cookie: cookie, resume: make([]byte, cookieMACSize)}
+	if ok, err := s.resumeSession(c, 1, response, otherAddr); ok || !errors.Is(err, ErrInvalidProof) {
+		t.Errorf("resumeSession() with a wrong proof = %v, %v, want %v", ok, err, ErrInvalidProof)
+	}
+}
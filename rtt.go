@@ -0,0 +1,80 @@
+package rudp
+
+import (
+	"time"
+)
+
+// rttEstimator tracks smoothed round-trip time and derives the retransmission
+// timeout from it, following RFC 6298
+type rttEstimator struct {
+	srtt    time.Duration // Smoothed round-trip time
+	rttvar  time.Duration // Round-trip time variation
+	rto     time.Duration // Base retransmission timeout
+	min     time.Duration
+	max     time.Duration
+	sampled bool // Whether any sample has been taken yet
+}
+
+// newRTTEstimator creates an estimator that uses initial as the timeout until the first sample
+func newRTTEstimator(initial, min, max time.Duration) rttEstimator {
+	e := rttEstimator{min: min, max: max}
+	e.rto = e.clamp(initial)
+	return e
+}
+
+// update folds a new round-trip sample into the estimate
+func (e *rttEstimator) update(sample time.Duration) {
+	if sample <= 0 {
+		return
+	}
+
+	if !e.sampled {
+		e.srtt = sample
+		e.rttvar = sample / 2
+		e.sampled = true
+	} else {
+		delta := e.srtt - sample
+		if delta < 0 {
+			delta = -delta
+		}
+		e.rttvar = (3*e.rttvar + delta) / 4
+		e.srtt = (7*e.srtt + sample) / 8
+	}
+
+	e.rto = e.clamp(e.srtt + 4*e.rttvar)
+}
+
+// timeout returns how long to wait for an ack of a packet that has been sent
+// attempts times, doubling the base timeout for every retransmission
+func (e *rttEstimator) timeout(attempts int) time.Duration {
+	rto := e.rto
+	for i := 1; i < attempts && rto < e.max; i++ {
+		rto *= 2
+	}
+	return e.clamp(rto)
+}
+
+// clamp bounds a timeout to the configured minimum and maximum
+func (e *rttEstimator) clamp(d time.Duration) time.Duration {
+	if d < e.min {
+		return e.min
+	}
+	if d > e.max {
+		return e.max
+	}
+	return d
+}
+
+// RTT returns the smoothed round-trip time, or zero if it has not been measured yet
+func (c *Connection) RTT() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.rtt.srtt
+}
+
+// RTTVar returns the round-trip time variation, or zero if it has not been measured yet
+func (c *Connection) RTTVar() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.rtt.rttvar
+}
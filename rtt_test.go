@@ -0,0 +1,181 @@
+package rudp
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestRTTEstimatorUpdate(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name       string
+		samples    []time.Duration
+		wantSRTT   time.Duration
+		wantRTTVar time.Duration
+		wantRTO    time.Duration
+	}{
+		{"initial", nil, 0, 0, 100 * ms},
+		{"first sample", []time.Duration{100 * ms}, 100 * ms, 50 * ms, 300 * ms},
+		{"steady", []time.Duration{100 * ms, 100 * ms}, 100 * ms, 37500 * time.Microsecond, 250 * ms},
+		{"spike", []time.Duration{100 * ms, 180 * ms}, 110 * ms, 57500 * time.Microsecond, 340 * ms},
+		{"ignored sample", []time.Duration{0, -ms}, 0, 0, 100 * ms},
+		{"clamped to minimum", []time.Duration{ms}, ms, ms / 2, 20 * ms},
+		{"clamped to maximum", []time.Duration{time.Second}, time.Second, 500 * ms, 2 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newRTTEstimator(DefaultRetransmissionTimeout, DefaultMinRetransmissionTimeout, DefaultMaxRetransmissionTimeout)
+			for _, sample := range tt.samples {
+				e.update(sample)
+			}
+			if e.srtt != tt.wantSRTT || e.rttvar != tt.wantRTTVar || e.rto != tt.wantRTO {
+				t.Errorf("srtt %v, rttvar %v, rto %v, want %v, %v and %v",
+					e.srtt, e.rttvar, e.rto, tt.wantSRTT, tt.wantRTTVar, tt.wantRTO)
+			}
+		})
+	}
+}
+
+func TestRTTEstimatorTimeout(t *testing.T) {
+	e := newRTTEstimator(300*time.Millisecond, DefaultMinRetransmissionTimeout, DefaultMaxRetransmissionTimeout)
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 300 * time.Millisecond},
+		{1, 300 * time.Millisecond},
+		{2, 600 * time.Millisecond},
+		{3, 1200 * time.Millisecond},
+		{4, 2 * time.Second},
+		{20, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := e.timeout(tt.attempts); got != tt.want {
+			t.Errorf("timeout(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestAcknowledgeSamplesRTT(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		want     bool
+	}{
+		{"sent once", 1, true},
+		{"retransmitted", 2, false}, // Karn: the ack may be for either copy
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConnection(DefaultConfig())
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			packet := &Packet{Type: DATA, Mode: Reliable}
+			c.admit(packet)
+			packet.Attempts = tt.attempts
+			packet.LastSent = time.Now().Add(-40 * time.Millisecond)
+
+			c.acknowledge(packet.Sequence, time.Now())
+			if _, pending := c.pendingAcks[packet.Sequence]; pending {
+				t.Error("acknowledged packet still pending")
+			}
+			if sampled := c.rtt.sampled; sampled != tt.want {
+				t.Errorf("sampled RTT = %v, want %v", sampled, tt.want)
+			}
+		})
+	}
+}
+
+func TestDelayedAckRTT(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name     string
+		ack      uint16
+		ackBits  uint32
+		data     []byte
+		wantSRTT time.Duration // Zero if no sample is taken
+	}{
+		{"delay taken off", 1, 0, binary.LittleEndian.AppendUint32(nil, 25000), 15 * ms},
+		{"no delay reported", 1, 0, nil, 40 * ms},
+		{"acked by the bits", 2, 0b1, binary.LittleEndian.AppendUint32(nil, 25000), 0}, // Held for an unknown time
+		{"delay longer than the round trip", 1, 0, binary.LittleEndian.AppendUint32(nil, 50000), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConnection(DefaultConfig())
+			c.localSequence = 1
+			c.mu.Lock()
+			packet := &Packet{Type: DATA, Mode: Reliable}
+			c.admit(packet)
+			packet.Attempts = 1
+			packet.LastSent = time.Now().Add(-40 * ms)
+			c.mu.Unlock()
+
+			c.handleAck(&Packet{Type: ACK, Ack: tt.ack, AckBits: tt.ackBits, Data: tt.data})
+			if len(c.pendingAcks) != 0 {
+				t.Fatal("acknowledged packet still pending")
+			}
+			// Allow for the time the test itself takes
+			if srtt := c.RTT(); srtt < tt.wantSRTT || srtt > tt.wantSRTT+5*ms || (tt.wantSRTT == 0) != (srtt == 0) {
+				t.Errorf("RTT() = %v, want %v", srtt, tt.wantSRTT)
+			}
+		})
+	}
+}
+
+func TestAckDelayReported(t *testing.T) {
+	c := newTestConnection(DefaultConfig())
+	if err := c.HandleIncomingPacket(&Packet{Type: DATA, Sequence: 0, Mode: Reliable, Ack: ^uint16(0)}); err != nil {
+		t.Fatalf("HandleIncomingPacket() error = %v", err)
+	}
+	c.ackTimer.Stop()
+	time.Sleep(5 * time.Millisecond)
+	c.flushAck()
+
+	sent := drainOutbound(c)
+	if len(sent) != 1 || sent[0].Type != ACK {
+		t.Fatalf("sent %v, want a standalone ACK", sent)
+	}
+	if delay := parseAckDelay(sent[0].Data); delay < 5*time.Millisecond || delay > time.Second {
+		t.Errorf("ACK reports a delay of %v, want the 5ms the packet was held", delay)
+	}
+}
+
+// listenTestServer starts a server on a free loopback port and returns its address
+func listenTestServer(t *testing.T, s *Server) string {
+	t.Helper()
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s.conn.LocalAddr().String()
+}
+
+func TestLoopbackRTT(t *testing.T) {
+	s := NewServer()
+	addr := listenTestServer(t, s)
+	c := NewClient()
+	if err := c.Connect(addr); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer c.Close()
+
+	// The server sends nothing back, so every ack arrives in a standalone ACK held for AckDelay
+	for i := 0; i < 5; i++ {
+		receipt, err := c.SendWithReceipt([]byte("ping"), Reliable)
+		if err != nil {
+			t.Fatalf("SendWithReceipt() error = %v", err)
+		}
+		if status := receipt.Wait(); status != DeliveryDelivered {
+			t.Fatalf("receipt = %v, want %v", status, DeliveryDelivered)
+		}
+	}
+	if rtt := c.connection.RTT(); rtt == 0 || rtt >= DefaultAckDelay/2 {
+		t.Errorf("RTT() = %v on loopback, want it measured without the %v ack delay", rtt, DefaultAckDelay)
+	}
+}
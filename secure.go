@@ -0,0 +1,225 @@
+package rudp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+)
+
+// In secure mode the client and server exchange ephemeral X25519 public keys in
+// CONNECT_RESPONSE and CONNECT_ACK and derive one AES-256-GCM key per direction.
+// Every packet after the handshake carries an explicit 64-bit counter, used as the
+// nonce, followed by the sealed payload; the header is authenticated as associated
+// data. Counters never repeat, since retransmissions are sealed again with a new
+// one, and each side rejects counters it has already accepted.
+const (
+	publicKeySize  = 32                    // X25519 public key
+	sessionKeySize = 32                    // AES-256
+	counterSize    = 8                     // Explicit nonce counter sent with every packet
+	tagSize        = 16                    // GCM authentication tag
+	SecureOverhead = counterSize + tagSize // Bytes secure mode adds to every packet
+	replayWindow   = 1024                  // Counters accepted behind the newest one, in packets
+	replayWords    = replayWindow / 64     // Size of the replay bitmap in words
+)
+
+// Labels separating the keys of the two directions
+var (
+	clientKeyLabel = []byte("rudp client to server")
+	serverKeyLabel = []byte("rudp server to client")
+	tokenKeyLabel  = []byte("rudp connection token")
+)
+
+// session holds the keys and nonce state of a secure connection
+type session struct {
+	mu          sync.Mutex
+	send        cipher.AEAD
+	recv        cipher.AEAD
+	sendCounter uint64
+	replay      replayFilter
+	secret      []byte // Mixed into the keys derived from the connection token, so the token alone is not enough
+}
+
+// replayFilter remembers which counters were accepted within the window behind the newest one
+type replayFilter struct {
+	newest uint64
+	any    bool
+	bitmap [replayWords]uint64 // Bit n%replayWindow is set if counter n was accepted
+}
+
+// newKeyPair generates an ephemeral X25519 key pair for the handshake
+func newKeyPair() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// newSession derives the session keys from the key exchange. The cookie of the
+// handshake and the session key of a connect token, if any, salt the derivation,
+// and both public keys are bound into it.
+func newSession(private *ecdh.PrivateKey, peerPublic []byte, cookie []byte, tokenKey []byte, isClient bool) (*session, error) {
+	peer, err := ecdh.X25519().NewPublicKey(peerPublic)
+	if err != nil {
+		return nil, ErrKeyExchange
+	}
+	shared, err := private.ECDH(peer)
+	if err != nil {
+		return nil, ErrKeyExchange
+	}
+
+	clientPublic, serverPublic := private.PublicKey().Bytes(), peerPublic
+	if !isClient {
+		clientPublic, serverPublic = serverPublic, clientPublic
+	}
+	transcript := append(append([]byte{}, clientPublic...), serverPublic...)
+
+	salt := append(append([]byte{}, cookie...), tokenKey...)
+	prk := hkdfExtract(salt, shared)
+	clientKey := hkdfExpand(prk, append(append([]byte{}, clientKeyLabel...), transcript...), sessionKeySize)
+	serverKey := hkdfExpand(prk, append(append([]byte{}, serverKeyLabel...), transcript...), sessionKeySize)
+
+	sendKey, recvKey := clientKey, serverKey
+	if !isClient {
+		sendKey, recvKey = serverKey, clientKey
+	}
+
+	s := &session{secret: hkdfExpand(prk, append(append([]byte{}, tokenKeyLabel...), transcript...), sessionKeySize)}
+	if s.send, err = newAEAD(sendKey); err != nil {
+		return nil, err
+	}
+	if s.recv, err = newAEAD(recvKey); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// acceptKeyExchange completes the server side of the key exchange for a client's
+// public key, returning the session and the server's public key to send back
+func acceptKeyExchange(clientPublic []byte, cookie []byte, tokenKey []byte) (*session, []byte, error) {
+	if len(clientPublic) != publicKeySize {
+		return nil, nil, ErrKeyExchange
+	}
+	private, err := newKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+	sess, err := newSession(private, clientPublic, cookie, tokenKey, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sess, private.PublicKey().Bytes(), nil
+}
+
+// newAEAD creates an AES-GCM cipher from a session key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// hkdfExtract is the extract step of HKDF-SHA256 (RFC 5869)
+func hkdfExtract(salt, secret []byte) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+// hkdfExpand is the expand step of HKDF-SHA256 (RFC 5869)
+func hkdfExpand(prk, info []byte, length int) []byte {
+	var out, block []byte
+	for i := byte(1); len(out) < length; i++ {
+		mac := hmac.New(sha256.New, prk)
+		mac.Write(block)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		block = mac.Sum(nil)
+		out = append(out, block...)
+	}
+	return out[:length]
+}
+
+// nonce expands a packet counter into a GCM nonce
+func nonce(counter uint64) []byte {
+	n := make([]byte, 12)
+	binary.LittleEndian.PutUint64(n[4:], counter)
+	return n
+}
+
+// seal serializes a packet with its payload encrypted and its header authenticated
+func (s *session) seal(packet *Packet) []byte {
+	s.mu.Lock()
+	counter := s.sendCounter
+	s.sendCounter++
+	s.mu.Unlock()
+
+	buf := packet.marshalHeader(SecureOverhead + len(packet.Data))
+	header := append([]byte{}, buf...)
+	buf = binary.LittleEndian.AppendUint64(buf, counter)
+	return s.send.Seal(buf, nonce(counter), packet.Data, header)
+}
+
+// open authenticates and decrypts the payload of a received packet in place,
+// rejecting packets that were tampered with, not sealed by the peer, or replayed
+func (s *session) open(packet *Packet) error {
+	if len(packet.Data) < SecureOverhead {
+		return ErrAuthenticationFailed
+	}
+
+	counter := binary.LittleEndian.Uint64(packet.Data[:counterSize])
+	header := packet.marshalHeader(len(packet.Data))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.replay.seen(counter) {
+		return ErrReplayed
+	}
+	plaintext, err := s.recv.Open(nil, nonce(counter), packet.Data[counterSize:], header)
+	if err != nil {
+		return ErrAuthenticationFailed
+	}
+	s.replay.accept(counter)
+
+	packet.Data = plaintext
+	return nil
+}
+
+// seen reports whether a counter was already accepted or is too old to tell
+func (r *replayFilter) seen(counter uint64) bool {
+	if !r.any || counter > r.newest {
+		return false
+	}
+	if r.newest-counter >= replayWindow {
+		return true
+	}
+	bit := counter % replayWindow
+	return r.bitmap[bit/64]&(1<<(bit%64)) != 0
+}
+
+// accept records a counter, sliding the window forward if it is the newest
+func (r *replayFilter) accept(counter uint64) {
+	switch {
+	case !r.any || (counter > r.newest && counter-r.newest >= replayWindow):
+		r.bitmap = [replayWords]uint64{}
+		r.newest = counter
+		r.any = true
+	case counter > r.newest:
+		// Forget the counters that slots skipped over held one window ago
+		for n := r.newest + 1; n < counter; n++ {
+			r.clear(n)
+		}
+		r.newest = counter
+	}
+	bit := counter % replayWindow
+	r.bitmap[bit/64] |= 1 << (bit % 64)
+}
+
+// clear unmarks the slot of a counter
+func (r *replayFilter) clear(counter uint64) {
+	bit := counter % replayWindow
+	r.bitmap[bit/64] &^= 1 << (bit % 64)
+}
@@ -0,0 +1,172 @@
+package rudp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestSessions runs the key exchange and returns the client and server sessions
+func newTestSessions(t *testing.T) (*session, *session) {
+	t.Helper()
+	cookie := bytes.Repeat([]byte{1}, cookieSize)
+	private, err := newKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, serverPublic, err := acceptKeyExchange(private.PublicKey().Bytes(), cookie, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newSession(private, serverPublic, cookie, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestSessionOpen(t *testing.T) {
+	client, server := newTestSessions(t)
+	packet := &Packet{Type: DATA, ClientID: 5, Sequence: 9, Mode: Reliable, Channel: 1, Data: []byte("secret")}
+
+	tests := []struct {
+		name    string
+		modify  func(buf []byte) []byte
+		wantErr error
+	}{
+		{"valid", func(buf []byte) []byte { return buf }, nil},
+		{"tampered payload", func(buf []byte) []byte {
+			buf[len(buf)-tagSize-1] ^= 0x01
+			return buf
+		}, ErrAuthenticationFailed},
+		{"tampered tag", func(buf []byte) []byte {
+			buf[len(buf)-1] ^= 0x01
+			return buf
+		}, ErrAuthenticationFailed},
+		{"tampered header", func(buf []byte) []byte {
+			buf[17] ^= 0x01 // Channel
+			return buf
+		}, ErrAuthenticationFailed},
+		{"tampered counter", func(buf []byte) []byte {
+			buf[HeaderSize] ^= 0x01
+			return buf
+		}, ErrAuthenticationFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := tt.modify(client.seal(packet))
+			var got Packet
+			if err := got.Unmarshal(buf); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			err := server.open(&got)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("open() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(got.Data, packet.Data) {
+				t.Errorf("data = %q, want %q", got.Data, packet.Data)
+			}
+		})
+	}
+}
+
+func TestSessionOpenRejects(t *testing.T) {
+	client, server := newTestSessions(t)
+
+	var short Packet
+	short.Data = make([]byte, SecureOverhead-1)
+	if err := server.open(&short); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("short packet: open() error = %v, want %v", err, ErrAuthenticationFailed)
+	}
+
+	// A packet sealed for the other direction is not accepted back by its sender
+	var reflected Packet
+	if err := reflected.Unmarshal(client.seal(&Packet{Type: DATA})); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.open(&reflected); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("reflected packet: open() error = %v, want %v", err, ErrAuthenticationFailed)
+	}
+}
+
+func TestSessionReplay(t *testing.T) {
+	client, server := newTestSessions(t)
+	buf := client.seal(&Packet{Type: DATA, Data: []byte("once")})
+
+	for i, wantErr := range []error{nil, ErrReplayed, ErrReplayed} {
+		var packet Packet
+		if err := packet.Unmarshal(buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := server.open(&packet); !errors.Is(err, wantErr) {
+			t.Errorf("delivery %d: open() error = %v, want %v", i+1, err, wantErr)
+		}
+	}
+}
+
+func TestReplayFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		accepted []uint64
+		counter  uint64
+		want     bool
+	}{
+		{"first", nil, 0, false},
+		{"repeated", []uint64{5}, 5, true},
+		{"newer", []uint64{5}, 6, false},
+		{"late within window", []uint64{5, 100}, 50, false},
+		{"late repeated", []uint64{50, 100}, 50, true},
+		{"too old", []uint64{replayWindow + 10}, 10, true},
+		{"oldest in window", []uint64{replayWindow + 10}, 11, false},
+		{"slot reused after slide", []uint64{3, replayWindow + 2, replayWindow + 4}, replayWindow + 3, false},
+		{"far jump clears window", []uint64{1, 2, 1 + 3*replayWindow}, 2 + 2*replayWindow, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r replayFilter
+			for _, counter := range tt.accepted {
+				r.accept(counter)
+			}
+			if got := r.seen(tt.counter); got != tt.want {
+				t.Errorf("seen(%d) = %v, want %v", tt.counter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecureHandshakeDuplicated(t *testing.T) {
+	s, err := NewServerWithConfig(Config{Secure: true})
+	if err != nil {
+		t.Fatalf("NewServerWithConfig() error = %v", err)
+	}
+	received := make(chan []byte, 1)
+	s.OnMessage = func(_ *Connection, packet *Packet) { received <- packet.Data }
+	proxy := newTestProxy(t, listenTestServer(t, s))
+	proxy.copies.Store(2)
+
+	// Each CONNECT reaches the server twice and is answered with two challenges
+	// carrying different cookies; both sides must still derive the same keys
+	c, err := NewClientWithConfig(Config{Secure: true})
+	if err != nil {
+		t.Fatalf("NewClientWithConfig() error = %v", err)
+	}
+	if err := c.Connect(proxy.addr); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Send([]byte("sealed"), Reliable); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	select {
+	case data := <-received:
+		if string(data) != "sealed" {
+			t.Errorf("server received %q, want %q", data, "sealed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message never delivered over the secure session")
+	}
+}
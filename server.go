@@ -1,31 +1,66 @@
 package rudp
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/binary"
 	"errors"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/cbodonnell/rudp/token"
 )
 
 // Server manages multiple UDP connections
 type Server struct {
-	mu          sync.RWMutex
-	conn        *net.UDPConn
-	connections map[uint32]*Connection // Keyed by ClientID
+	mu           sync.RWMutex
+	conn         *net.UDPConn
+	connections  map[uint32]*Connection // Keyed by ClientID
+	config       Config
+	cookieSecret []byte // Key signing the cookies of CONNECT_CHALLENGE
+	dropped      atomic.Uint64
+	accepted     atomic.Uint64 // Handshakes that created a connection
+	rejected     atomic.Uint64 // Handshakes answered with CONNECT_REJECT
+	limiter      *tokenBucket  // Enforces ServerSendRate, nil if unlimited
+	closedStats  Stats         // Counters of connections that were closed
 
 	// Events
-	OnConnect    func(*Connection)
-	OnDisconnect func(*Connection)
-	OnMessage    func(*Connection, *Packet)
+	OnConnectRequest func(addr *net.UDPAddr, clientID uint32, payload []byte) ([]byte, RejectReason) // Accepts a client with RejectNone and an optional reply payload, or rejects it
+	OnConnectAborted func(addr *net.UDPAddr, clientID uint32)                                        // Called when a client accepted by OnConnectRequest is rejected because the reply payload does not fit in a packet
+	OnConnect        func(*Connection)
+	OnDisconnect     func(*Connection, DisconnectReason)
+	OnMessage        func(*Connection, *Packet)
+	OnDeliveryFailed func(*Connection, *Packet)
+	OnAddressChanged func(conn *Connection, oldAddr, newAddr *net.UDPAddr) // Called when a client moved to a new address
 
 	done chan struct{}
 }
 
-// NewServer creates a new UDP server
+// NewServer creates a new UDP server using the default configuration
 func NewServer() *Server {
+	return newServer(DefaultConfig())
+}
+
+// NewServerWithConfig creates a new UDP server using the given configuration.
+// The configuration is applied to every connection the server accepts.
+func NewServerWithConfig(config Config) (*Server, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return newServer(config), nil
+}
+
+// newServer creates a server from an already validated config
+func newServer(config Config) *Server {
+	config = config.withDefaults()
 	return &Server{
-		connections: make(map[uint32]*Connection),
-		done:        make(chan struct{}),
+		connections:  make(map[uint32]*Connection),
+		config:       config,
+		cookieSecret: newCookieSecret(),
+		limiter:      newTokenBucket(config.ServerSendRate, config.MaxPacketSize),
+		done:         make(chan struct{}),
 	}
 }
 
@@ -49,7 +84,7 @@ func (s *Server) Listen(addr string) error {
 
 // handlePackets processes incoming UDP packets
 func (s *Server) handlePackets() {
-	buffer := make([]byte, MaxPacketSize)
+	buffer := make([]byte, s.config.MaxPacketSize)
 
 	for {
 		select {
@@ -73,13 +108,26 @@ func (s *Server) handlePackets() {
 func (s *Server) handlePacket(data []byte, addr *net.UDPAddr) error {
 	// Parse packet to determine type and client ID
 	packet := &Packet{}
-	if err := packet.Unmarshal(data); err != nil {
+	if err := s.config.unmarshal(packet, data); err != nil {
+		s.dropped.Add(1)
+
+		// Tell clients of another protocol version why they cannot connect
+		if errors.Is(err, ErrVersionMismatch) && packet.Type == CONNECT {
+			s.rejectVersion(packet.ClientID, addr, len(data))
+		}
 		return err
 	}
+	if s.config.Checksum && !packet.checksummed {
+		s.dropped.Add(1)
+		return ErrChecksumMismatch
+	}
 
-	// Handle CONNECT packets specially
-	if packet.Type == CONNECT {
+	// Handle handshake packets specially
+	switch packet.Type {
+	case CONNECT:
 		return s.handleConnect(packet, addr)
+	case CONNECT_RESPONSE:
+		return s.handleConnectResponse(packet, addr)
 	}
 
 	// Route to connection by ClientID (O(1) lookup)
@@ -93,52 +141,198 @@ func (s *Server) handlePacket(data []byte, addr *net.UDPAddr) error {
 		return nil
 	}
 
-	// Update address if client reconnected from different port/IP
+	// Packets from a new address only move the connection once the client proves it holds the path key
 	if conn.RemoteAddr().String() != addr.String() {
-		conn.UpdateAddr(addr)
+		return s.handleMigration(conn, packet, addr)
 	}
-
 	return conn.HandleIncomingPacket(packet)
 }
 
-// handleConnect processes CONNECT packets and establishes new connections
+// handleConnect answers a CONNECT with a CONNECT_CHALLENGE. No state is kept for the
+// client until it echoes the cookie, so spoofed CONNECTs cannot exhaust the server, and
+// the challenge is never larger than the padded CONNECT, so they cannot be reflected
+// with amplification.
 func (s *Server) handleConnect(packet *Packet, addr *net.UDPAddr) error {
+	if len(packet.Data) < connectPadding {
+		return ErrInvalidPacket
+	}
+	if appID := binary.LittleEndian.Uint32(packet.Data); appID != s.config.AppID {
+		s.reject(packet.ClientID, addr, RejectAppMismatch)
+		return nil
+	}
+
+	challenge := &Packet{
+		Type:     CONNECT_CHALLENGE,
+		ClientID: packet.ClientID,
+		Data:     newCookie(s.cookieSecret, addr, packet.ClientID, time.Now()),
+	}
+	s.conn.WriteToUDP(s.config.marshal(challenge), addr)
+
+	return nil
+}
+
+// handleConnectResponse verifies the cookie echoed by a client and establishes its connection.
+// In secure mode the response also carries the client's public key, and the server's is
+// returned in CONNECT_ACK. Servers with a TokenSecret also require a valid connect token.
+func (s *Server) handleConnectResponse(packet *Packet, addr *net.UDPAddr) error {
 	clientID := packet.ClientID
+	response, err := parseConnectResponse(packet.Data)
+	if err != nil {
+		return err
+	}
+	if err := verifyCookie(s.cookieSecret, response.cookie, addr, clientID, time.Now()); err != nil {
+		return err
+	}
 
 	s.mu.Lock()
 	conn, exists := s.connections[clientID]
+	full := s.config.MaxConnections > 0 && len(s.connections) >= s.config.MaxConnections
+
+	if len(response.resume) > 0 {
+		// The client is resuming a suspended connection rather than creating a new one
+		s.mu.Unlock()
 
-	if exists {
-		// Client reconnecting from different address
-		if conn.RemoteAddr().String() != addr.String() {
-			conn.UpdateAddr(addr)
+		if ok, err := s.resumeSession(conn, clientID, response, addr); !ok {
+			return err
+		}
+	} else if exists {
+		// CONNECT_ACK was lost. Connections cannot be taken over by a new handshake, only resent
+		// ones are answered; moving to another address takes resuming or a path challenge.
+		if !bytes.Equal(conn.handshake, packet.Data) {
+			s.mu.Unlock()
+			return ErrClientIDInUse
 		}
 		s.mu.Unlock()
 	} else {
-		// New connection
-		conn = NewConnection(s.conn, addr, clientID)
-		s.connections[clientID] = conn
 		s.mu.Unlock()
 
-		if s.OnConnect != nil {
-			s.OnConnect(conn)
+		if full {
+			s.reject(clientID, addr, RejectServerFull)
+			return nil
+		}
+		if conn, err = s.accept(packet, response, addr); err != nil {
+			return err
+		}
+		if conn == nil {
+			return nil
 		}
-
-		go s.handleConnection(clientID, conn)
 	}
 
 	// Send CONNECT_ACK
 	ackPacket := &Packet{
 		Type:     CONNECT_ACK,
 		ClientID: clientID,
-		Data:     []byte{},
+		Data:     conn.handshakeReply,
 	}
-	ackData := ackPacket.Marshal()
+	ackData := s.config.marshal(ackPacket)
 	s.conn.WriteToUDP(ackData, addr)
 
 	return nil
 }
 
+// accept decides whether to accept a new client and creates its connection.
+// It returns a nil connection if the client was rejected.
+func (s *Server) accept(packet *Packet, response *connectResponse, addr *net.UDPAddr) (*Connection, error) {
+	clientID := packet.ClientID
+
+	var tok *token.Token
+	var tokenKey []byte
+	if len(s.config.TokenSecret) > 0 {
+		var err error
+		if tok, err = s.verifyToken(response); err != nil {
+			s.reject(clientID, addr, RejectInvalidToken)
+			return nil, err
+		}
+		tokenKey = tok.SessionKey
+	}
+
+	// Everything that can fail is done before asking the application, so a client
+	// OnConnectRequest accepts is only turned away for a reply that does not fit
+	ack := &connectAck{token: newConnectionToken()}
+	var sess *session
+	if s.config.secure() {
+		var err error
+		sess, ack.publicKey, err = acceptKeyExchange(response.publicKey, response.cookie, tokenKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.config.overhead()+len(ack.marshal()) > s.config.MaxPacketSize {
+		s.reject(clientID, addr, RejectDenied)
+		return nil, ErrPacketTooLarge
+	}
+
+	if s.OnConnectRequest != nil {
+		var reason RejectReason
+		if ack.payload, reason = s.OnConnectRequest(addr, clientID, response.payload); reason != RejectNone {
+			s.reject(clientID, addr, reason)
+			return nil, nil
+		}
+	}
+
+	reply := ack.marshal()
+	if s.config.overhead()+len(reply) > s.config.MaxPacketSize {
+		s.reject(clientID, addr, RejectDenied)
+		if s.OnConnectAborted != nil {
+			s.OnConnectAborted(addr, clientID)
+		}
+		return nil, ErrPacketTooLarge
+	}
+
+	// New connection
+	conn := newConnection(s.conn, addr, clientID, s.config)
+	conn.session = sess
+	conn.serverLimiter = s.limiter
+	conn.handshake = packet.Data
+	conn.handshakeReply = reply
+	conn.handshakePayload = response.payload
+	conn.pathKey = connectionKey(sess, ack.token, pathKeyLabel)
+	if s.config.ResumeGracePeriod > 0 {
+		conn.resumeKey = connectionKey(sess, ack.token, resumeKeyLabel)
+	}
+	if tok != nil {
+		conn.userID = tok.UserID
+		conn.userData = tok.UserData
+	}
+	conn.onDeliveryFailed = func(packet *Packet) {
+		if s.OnDeliveryFailed != nil {
+			s.OnDeliveryFailed(conn, packet)
+		}
+	}
+	conn.start()
+	s.accepted.Add(1)
+
+	s.mu.Lock()
+	s.connections[clientID] = conn
+	s.mu.Unlock()
+
+	if s.OnConnect != nil {
+		s.OnConnect(conn)
+	}
+
+	go s.handleConnection(clientID, conn)
+	return conn, nil
+}
+
+// verifyToken opens the connect token of a response, checks that it was issued for
+// this server, and that the client proved it holds the token's session key
+func (s *Server) verifyToken(response *connectResponse) (*token.Token, error) {
+	if len(response.token) == 0 {
+		return nil, ErrTokenRequired
+	}
+	tok, err := token.Open(response.token, s.config.TokenSecret, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !tok.AllowsServer(s.config.PublicAddr) {
+		return nil, token.ErrWrongServer
+	}
+	if !hmac.Equal(response.proof, tokenProof(tok.SessionKey, response)) {
+		return nil, ErrInvalidProof
+	}
+	return tok, nil
+}
+
 // handleConnection processes packets from a specific connection
 func (s *Server) handleConnection(clientID uint32, conn *Connection) {
 	for {
@@ -154,11 +348,13 @@ func (s *Server) handleConnection(clientID uint32, conn *Connection) {
 
 	// Connection closed
 	s.mu.Lock()
-	delete(s.connections, clientID)
+	if s.connections[clientID] == conn {
+		s.removeConnection(clientID, conn)
+	}
 	s.mu.Unlock()
 
 	if s.OnDisconnect != nil {
-		s.OnDisconnect(conn)
+		s.OnDisconnect(conn, conn.DisconnectReason())
 	}
 }
 
@@ -172,9 +368,9 @@ func (s *Server) cleanupConnections() {
 		case <-ticker.C:
 			s.mu.Lock()
 			for clientID, conn := range s.connections {
-				if !conn.IsConnected() {
-					conn.Close()
-					delete(s.connections, clientID)
+				if conn.expired() {
+					conn.shutdown(DisconnectTimeout, nil)
+					s.removeConnection(clientID, conn)
 				}
 			}
 			s.mu.Unlock()
@@ -184,14 +380,19 @@ func (s *Server) cleanupConnections() {
 	}
 }
 
-// Broadcast sends a packet to all connected clients
+// Broadcast sends a packet to all connected clients on the default channel 0
 func (s *Server) Broadcast(data []byte, mode DeliveryMode) error {
+	return s.BroadcastOnChannel(0, data, mode)
+}
+
+// BroadcastOnChannel sends a packet to all connected clients on the given channel
+func (s *Server) BroadcastOnChannel(channel uint8, data []byte, mode DeliveryMode) error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	errs := make([]error, 0)
 	for _, conn := range s.connections {
-		if err := conn.Send(data, mode); err != nil {
+		if err := conn.SendOnChannel(channel, data, mode); err != nil {
 			errs = append(errs, err)
 			continue
 		}
@@ -200,15 +401,46 @@ func (s *Server) Broadcast(data []byte, mode DeliveryMode) error {
 	return errors.Join(errs...)
 }
 
-// Close shuts down the server
-func (s *Server) Close() error {
-	close(s.done)
+// DroppedPackets returns the number of datagrams dropped because they were corrupted,
+// failed their checksum or were not RUDP packets of this protocol version
+func (s *Server) DroppedPackets() uint64 {
+	return s.dropped.Load()
+}
 
-	s.mu.Lock()
+// QueuedBytes returns the bytes waiting to be sent across all connections
+func (s *Server) QueuedBytes() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	queued := 0
 	for _, conn := range s.connections {
-		conn.Close()
+		queued += conn.QueuedBytes()
 	}
-	s.mu.Unlock()
+	return queued
+}
+
+// Close disconnects all clients and shuts down the server
+func (s *Server) Close() error {
+	s.mu.RLock()
+	conns := make([]*Connection, 0, len(s.connections))
+	for _, conn := range s.connections {
+		conns = append(conns, conn)
+	}
+	s.mu.RUnlock()
+
+	// Disconnect concurrently so lingering connections don't delay each other,
+	// and before stopping the read loop so their acks still arrive
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn *Connection) {
+			defer wg.Done()
+			conn.CloseWithReason(DisconnectServerShutdown, nil)
+		}(conn)
+	}
+	wg.Wait()
+
+	close(s.done)
 
 	if s.conn != nil {
 		return s.conn.Close()
@@ -0,0 +1,159 @@
+package rudp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of how a connection is performing
+type Stats struct {
+	PacketsSent         uint64        // Datagrams sent, including retransmissions and control packets
+	PacketsReceived     uint64        // Datagrams received from the peer and accepted
+	BytesSent           uint64        // Size of the datagrams sent
+	BytesReceived       uint64        // Size of the datagrams received
+	ReliableSent        uint64        // Reliable packets sent for the first time
+	Retransmissions     uint64        // Reliable packets sent again because no ack arrived in time
+	DeliveryFailures    uint64        // Reliable messages given up on after MaxRetransmissions
+	Duplicates          uint64        // Packets received more than once
+	OutOfOrder          uint64        // Packets received after a newer one
+	ReassemblyRefusals  uint64        // Fragments refused for lack of reassembly memory, left for the peer to retransmit
+	OrderWindowRefusals uint64        // Reliable ordered packets refused for being too far ahead of their stream, left for the peer to retransmit
+	LateOrdered         uint64        // Ordered packets dropped because their stream had already moved past them
+	OutboundDrops       uint64        // Batched unreliable messages dropped because the outbound buffer was full when their batch was sent
+	PacketLoss          float64       // Estimated share of reliable packets lost, in percent
+	RTT                 time.Duration // Smoothed round-trip time
+	RTTVar              time.Duration // Round-trip time variation
+	PendingAcks         int           // Reliable packets in flight, sent but not yet acked
+	CongestionWindow    int           // Reliable packets that may be in flight
+	QueuedBytes         int           // Bytes waiting to be sent
+	InboundQueued       int           // Packets waiting to be received by the application
+	OutboundQueued      int           // Packets waiting for the socket
+}
+
+// connectionCounters are the counters behind Stats, updated without the lock
+type connectionCounters struct {
+	packetsSent      atomic.Uint64
+	packetsReceived  atomic.Uint64
+	bytesSent        atomic.Uint64
+	bytesReceived    atomic.Uint64
+	reliableSent     atomic.Uint64
+	retransmissions  atomic.Uint64
+	deliveryFailures atomic.Uint64
+	duplicates       atomic.Uint64
+	outOfOrder       atomic.Uint64
+	reassemblyFull   atomic.Uint64
+	orderWindowFull  atomic.Uint64
+	lateOrdered      atomic.Uint64
+	outboundDrops    atomic.Uint64
+}
+
+// Stats returns a snapshot of the connection's counters and current state
+func (c *Connection) Stats() Stats {
+	stats := Stats{
+		PacketsSent:         c.counters.packetsSent.Load(),
+		PacketsReceived:     c.counters.packetsReceived.Load(),
+		BytesSent:           c.counters.bytesSent.Load(),
+		BytesReceived:       c.counters.bytesReceived.Load(),
+		ReliableSent:        c.counters.reliableSent.Load(),
+		Retransmissions:     c.counters.retransmissions.Load(),
+		DeliveryFailures:    c.counters.deliveryFailures.Load(),
+		Duplicates:          c.counters.duplicates.Load(),
+		OutOfOrder:          c.counters.outOfOrder.Load(),
+		ReassemblyRefusals:  c.counters.reassemblyFull.Load(),
+		OrderWindowRefusals: c.counters.orderWindowFull.Load(),
+		LateOrdered:         c.counters.lateOrdered.Load(),
+		OutboundDrops:       c.counters.outboundDrops.Load(),
+		QueuedBytes:         c.QueuedBytes(),
+		InboundQueued:       len(c.inbound),
+		OutboundQueued:      len(c.outbound),
+	}
+
+	c.mu.RLock()
+	stats.RTT = c.rtt.srtt
+	stats.RTTVar = c.rtt.rttvar
+	stats.PendingAcks = len(c.pendingAcks)
+	stats.CongestionWindow = c.congestion.Window()
+	c.mu.RUnlock()
+
+	stats.updateLoss()
+	return stats
+}
+
+// updateLoss estimates PacketLoss from the share of reliable transmissions that had to
+// be repeated. Lost acks count as lost packets, since the two cannot be told apart.
+func (s *Stats) updateLoss() {
+	s.PacketLoss = 0
+	if sent := s.ReliableSent + s.Retransmissions; sent > 0 {
+		s.PacketLoss = 100 * float64(s.Retransmissions) / float64(sent)
+	}
+}
+
+// add accumulates the counters of another connection
+func (s *Stats) add(other Stats) {
+	s.PacketsSent += other.PacketsSent
+	s.PacketsReceived += other.PacketsReceived
+	s.BytesSent += other.BytesSent
+	s.BytesReceived += other.BytesReceived
+	s.ReliableSent += other.ReliableSent
+	s.Retransmissions += other.Retransmissions
+	s.DeliveryFailures += other.DeliveryFailures
+	s.Duplicates += other.Duplicates
+	s.OutOfOrder += other.OutOfOrder
+	s.ReassemblyRefusals += other.ReassemblyRefusals
+	s.OrderWindowRefusals += other.OrderWindowRefusals
+	s.LateOrdered += other.LateOrdered
+	s.OutboundDrops += other.OutboundDrops
+}
+
+// ServerStats is a snapshot of how a server is performing. Counters are totals over
+// every connection the server has had, while RTT and RTTVar are averaged and the
+// other fields summed over the current connections.
+type ServerStats struct {
+	Stats
+	Connections        int    // Clients currently connected
+	HandshakesAccepted uint64 // Clients accepted as new connections
+	HandshakesRejected uint64 // CONNECT_REJECTs sent, for any RejectReason
+	DroppedPackets     uint64 // Datagrams dropped before reaching a connection
+}
+
+// Stats returns a snapshot of the server's counters and current state
+func (s *Server) Stats() ServerStats {
+	s.mu.RLock()
+	stats := ServerStats{
+		Stats:              s.closedStats,
+		Connections:        len(s.connections),
+		HandshakesAccepted: s.accepted.Load(),
+		HandshakesRejected: s.rejected.Load(),
+		DroppedPackets:     s.dropped.Load(),
+	}
+	conns := make([]*Connection, 0, len(s.connections))
+	for _, conn := range s.connections {
+		conns = append(conns, conn)
+	}
+	s.mu.RUnlock()
+
+	for _, conn := range conns {
+		cs := conn.Stats()
+		stats.add(cs)
+		stats.RTT += cs.RTT
+		stats.RTTVar += cs.RTTVar
+		stats.PendingAcks += cs.PendingAcks
+		stats.CongestionWindow += cs.CongestionWindow
+		stats.QueuedBytes += cs.QueuedBytes
+		stats.InboundQueued += cs.InboundQueued
+		stats.OutboundQueued += cs.OutboundQueued
+	}
+	if len(conns) > 0 {
+		stats.RTT /= time.Duration(len(conns))
+		stats.RTTVar /= time.Duration(len(conns))
+	}
+	stats.updateLoss()
+	return stats
+}
+
+// removeConnection forgets a closed connection, keeping its counters in the server's
+// totals. Must be called with the lock held.
+func (s *Server) removeConnection(clientID uint32, conn *Connection) {
+	delete(s.connections, clientID)
+	s.closedStats.add(conn.Stats())
+}
@@ -0,0 +1,53 @@
+package rudp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServerStats(t *testing.T) {
+	s := newServer(DefaultConfig())
+	s.accepted.Add(3)
+	s.rejected.Add(1)
+	s.dropped.Add(2)
+
+	conns := make([]*Connection, 3)
+	for i := range conns {
+		c := newConnection(nil, nil, uint32(i), DefaultConfig())
+		c.counters.packetsSent.Add(10)
+		c.counters.reliableSent.Add(3)
+		c.counters.retransmissions.Add(1)
+		c.counters.lateOrdered.Add(1)
+		c.rtt.srtt = time.Duration(i+1) * 10 * time.Millisecond
+		c.admit(&Packet{Type: DATA, Mode: Reliable})
+		s.connections[uint32(i)] = c
+		conns[i] = c
+	}
+
+	// The counters of a closed connection stay in the totals, its state does not
+	s.mu.Lock()
+	s.removeConnection(0, conns[0])
+	s.mu.Unlock()
+
+	stats := s.Stats()
+	if stats.Connections != 2 {
+		t.Errorf("Connections = %d, want 2", stats.Connections)
+	}
+	if stats.HandshakesAccepted != 3 || stats.HandshakesRejected != 1 || stats.DroppedPackets != 2 {
+		t.Errorf("handshakes accepted %d, rejected %d, dropped %d, want 3, 1 and 2",
+			stats.HandshakesAccepted, stats.HandshakesRejected, stats.DroppedPackets)
+	}
+	if stats.PacketsSent != 30 || stats.ReliableSent != 9 || stats.Retransmissions != 3 || stats.LateOrdered != 3 {
+		t.Errorf("PacketsSent %d, ReliableSent %d, Retransmissions %d, LateOrdered %d, want 30, 9, 3 and 3",
+			stats.PacketsSent, stats.ReliableSent, stats.Retransmissions, stats.LateOrdered)
+	}
+	if stats.PacketLoss != 25 {
+		t.Errorf("PacketLoss = %v, want 25", stats.PacketLoss)
+	}
+	if stats.RTT != 25*time.Millisecond {
+		t.Errorf("RTT = %v, want the 25ms average of current connections", stats.RTT)
+	}
+	if stats.PendingAcks != 2 || stats.OutboundQueued != 2 {
+		t.Errorf("PendingAcks %d, OutboundQueued %d, want 2 each from current connections", stats.PendingAcks, stats.OutboundQueued)
+	}
+}
@@ -0,0 +1,166 @@
+package rudp
+
+import (
+	"time"
+)
+
+// orderWindow is how far ahead of the next expected packet a stream buffers. Reliable
+// packets further ahead are refused before they are acked, so they are retransmitted
+// once the stream caught up, and a peer cannot make the buffer grow without bound.
+//
+// A gap in a stream is never filled if its packet is lost for good: an unreliable one
+// is never resent, and a reliable one is given up on after MaxRetransmissions. Streams of
+// UnreliableOrdered packets therefore move past a gap once it held them up for orderHold,
+// or when a packet arrives too far ahead for the window. For ReliableOrdered messages the
+// sender sends an ORDER_SKIP taking the place of each message it gave up on.
+const (
+	orderWindow = 1024
+	orderHold   = 100 * time.Millisecond // Time an unreliable stream waits for a missing packet
+)
+
+// orderedStream holds the sequence space and reorder buffer of one ordered or sequenced delivery mode
+// on one channel. Order sequences are independent of the ack sequence so that packets of
+// other modes or channels, dropped or not, never leave gaps in the stream.
+type orderedStream struct {
+	nextSend  uint16             // Order sequence assigned to the next outgoing packet
+	nextRecv  uint16             // Order sequence of the next packet to deliver
+	buffer    map[uint16]*Packet // Packets received ahead of nextRecv
+	heldSince time.Time          // When the missing packet at nextRecv started holding up the buffer
+}
+
+// streamKey identifies the ordered stream of a delivery mode on a channel
+type streamKey struct {
+	channel uint8
+	mode    DeliveryMode
+}
+
+// newOrderedStream creates an empty stream
+func newOrderedStream() *orderedStream {
+	return &orderedStream{buffer: make(map[uint16]*Packet)}
+}
+
+// stream returns the ordered stream of a delivery mode on a channel, creating it on first use.
+// Must be called with the lock held.
+func (c *Connection) stream(channel uint8, mode DeliveryMode) *orderedStream {
+	key := streamKey{channel: channel, mode: mode}
+	s, exists := c.streams[key]
+	if !exists {
+		s = newOrderedStream()
+		c.streams[key] = s
+	}
+	return s
+}
+
+// inWindow reports whether a packet is close enough to be buffered, or older than the next
+// expected one and so dropped as already delivered anyway. Sequenced packets always are.
+func (s *orderedStream) inWindow(packet *Packet) bool {
+	return packet.IsSequenced() || sequenceGreater(s.nextRecv, packet.OrderSequence) ||
+		packet.OrderSequence-s.nextRecv < orderWindow
+}
+
+// checkOrder refuses reliable ordered packets too far ahead of their stream to be buffered.
+// Unreliable ones are never refused, since the stream moves forward to make room for them.
+// Must be called with the lock held.
+func (c *Connection) checkOrder(packet *Packet) error {
+	if packet.IsOrdered() && packet.IsReliable() && !c.stream(packet.Channel, packet.Mode).inWindow(packet) {
+		return ErrOrderWindow
+	}
+	return nil
+}
+
+// push buffers a received packet and returns the packets that are now deliverable in order.
+// Packets older than the next expected one were already delivered or skipped and are dropped.
+// Sequenced packets are never buffered: any packet newer than the last delivered one is
+// delivered immediately, skipping whatever was lost in between. ORDER_SKIPs take the place
+// of a message in the stream and are returned like packets, for the caller to leave out.
+func (s *orderedStream) push(packet *Packet, now time.Time) []*Packet {
+	if sequenceGreater(s.nextRecv, packet.OrderSequence) {
+		return nil
+	}
+	if packet.IsSequenced() {
+		s.nextRecv = packet.OrderSequence + 1
+		return []*Packet{packet}
+	}
+
+	var ready []*Packet
+	start := s.nextRecv
+	if !s.inWindow(packet) {
+		if packet.IsReliable() {
+			return nil
+		}
+		ready = s.skipTo(packet.OrderSequence - orderWindow + 1)
+	}
+
+	held := len(s.buffer) > 0 && now.Sub(s.heldSince) >= orderHold
+	if _, exists := s.buffer[packet.OrderSequence]; !exists {
+		s.buffer[packet.OrderSequence] = packet
+	}
+	if held && !packet.IsReliable() {
+		ready = append(ready, s.skipTo(s.oldest())...)
+	}
+	ready = append(ready, s.drain()...)
+
+	// The hold is timed from when the packet now missing first held up another one
+	switch {
+	case len(s.buffer) == 0:
+		s.heldSince = time.Time{}
+	case s.heldSince.IsZero() || s.nextRecv != start:
+		s.heldSince = now
+	}
+	return ready
+}
+
+// drain removes the packets that follow on from nextRecv from the buffer, in order
+func (s *orderedStream) drain() []*Packet {
+	var ready []*Packet
+	for {
+		p, exists := s.buffer[s.nextRecv]
+		if !exists {
+			return ready
+		}
+		delete(s.buffer, s.nextRecv)
+		s.nextRecv++
+		ready = append(ready, p)
+	}
+}
+
+// skipTo gives up on the missing packets before an order sequence, returning the buffered
+// ones in between in order
+func (s *orderedStream) skipTo(seq uint16) []*Packet {
+	var ready []*Packet
+	for sequenceGreater(seq, s.nextRecv) {
+		if p, exists := s.buffer[s.nextRecv]; exists {
+			delete(s.buffer, s.nextRecv)
+			ready = append(ready, p)
+		}
+		s.nextRecv++
+	}
+	return ready
+}
+
+// oldest returns the lowest order sequence in the buffer, which must not be empty
+func (s *orderedStream) oldest() uint16 {
+	first := true
+	var oldest uint16
+	for seq := range s.buffer {
+		if first || sequenceGreater(oldest, seq) {
+			oldest = seq
+			first = false
+		}
+	}
+	return oldest
+}
+
+// queueOrderSkip tells the peer to move past a ReliableOrdered message that was given up
+// on, so the messages after it are not held up forever. The ORDER_SKIP is itself reliable
+// and ordered, taking the message's place in its stream. Must be called with the lock held.
+func (c *Connection) queueOrderSkip(message *Packet) {
+	c.queue(&Packet{
+		Type:          ORDER_SKIP,
+		ClientID:      c.clientID,
+		Mode:          ReliableOrdered,
+		Channel:       message.Channel,
+		OrderSequence: message.OrderSequence,
+		Timestamp:     time.Now().UnixNano(),
+	})
+}
@@ -0,0 +1,248 @@
+package rudp
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// orderSequences returns the order sequences of packets
+func orderSequences(packets []*Packet) []uint16 {
+	seqs := make([]uint16, 0, len(packets))
+	for _, p := range packets {
+		seqs = append(seqs, p.OrderSequence)
+	}
+	return seqs
+}
+
+func TestStreamPush(t *testing.T) {
+	start := time.Now()
+	type push struct {
+		seq  uint16
+		at   time.Duration // Since start
+		skip bool          // Push an ORDER_SKIP rather than a message
+	}
+
+	tests := []struct {
+		name   string
+		mode   DeliveryMode
+		pushes []push
+		want   [][]uint16 // Order sequences returned by each push
+	}{
+		{"in order", ReliableOrdered,
+			[]push{{seq: 0}, {seq: 1}},
+			[][]uint16{{0}, {1}}},
+		{"reordered", ReliableOrdered,
+			[]push{{seq: 1}, {seq: 2}, {seq: 0}},
+			[][]uint16{{}, {}, {0, 1, 2}}},
+		{"duplicate and old", ReliableOrdered,
+			[]push{{seq: 0}, {seq: 0}, {seq: 2}, {seq: 2}},
+			[][]uint16{{0}, {}, {}, {}}},
+		{"reliable waits for a lost message", ReliableOrdered,
+			[]push{{seq: 1}, {seq: 2, at: time.Hour}},
+			[][]uint16{{}, {}}},
+		{"reliable skipped by the sender", ReliableOrdered,
+			[]push{{seq: 1}, {seq: 2}, {seq: 0, skip: true}, {seq: 3}},
+			[][]uint16{{}, {}, {0, 1, 2}, {3}}},
+		{"skip does not replace a buffered message", ReliableOrdered,
+			[]push{{seq: 1}, {seq: 1, skip: true}, {seq: 0}},
+			[][]uint16{{}, {}, {0, 1}}},
+		{"reliable beyond the window", ReliableOrdered,
+			[]push{{seq: orderWindow}, {seq: 0}},
+			[][]uint16{{}, {0}}},
+		{"unreliable waits within the hold", UnreliableOrdered,
+			[]push{{seq: 1}, {seq: 2, at: orderHold - time.Millisecond}, {seq: 0, at: orderHold}},
+			[][]uint16{{}, {}, {0, 1, 2}}},
+		{"unreliable moves past a lost packet", UnreliableOrdered,
+			[]push{{seq: 1}, {seq: 2, at: orderHold / 2}, {seq: 4, at: orderHold}, {seq: 0, at: orderHold}},
+			[][]uint16{{}, {}, {1, 2}, {}}},
+		{"unreliable holds each gap in turn", UnreliableOrdered,
+			[]push{{seq: 1}, {seq: 3}, {seq: 5, at: orderHold}, {seq: 6, at: orderHold + time.Millisecond}, {seq: 7, at: 2 * orderHold}},
+			[][]uint16{{}, {}, {1}, {}, {3}}},
+		{"unreliable beyond the window", UnreliableOrdered,
+			[]push{{seq: 1}, {seq: 5}, {seq: orderWindow + 2}, {seq: 3}},
+			[][]uint16{{}, {}, {1}, {3}}},
+		{"sequenced", UnreliableSequenced,
+			[]push{{seq: 2}, {seq: 1}, {seq: 5}},
+			[][]uint16{{2}, {}, {5}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newOrderedStream()
+			for i, p := range tt.pushes {
+				packet := &Packet{Type: DATA, Mode: tt.mode, OrderSequence: p.seq}
+				if p.skip {
+					packet.Type = ORDER_SKIP
+				}
+				got := orderSequences(s.push(packet, start.Add(p.at)))
+				if !slices.Equal(got, tt.want[i]) {
+					t.Fatalf("push %d (seq %d) returned %v, want %v", i, p.seq, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStreamUnreliableLossDoesNotStall(t *testing.T) {
+	// Order sequence 0 is lost, and a burst fills the window before the hold expires
+	s := newOrderedStream()
+	now := time.Now()
+	var delivered []uint16
+	for seq := uint16(1); seq <= 3*orderWindow; seq++ {
+		packet := &Packet{Type: DATA, Mode: UnreliableOrdered, OrderSequence: seq}
+		delivered = append(delivered, orderSequences(s.push(packet, now))...)
+	}
+
+	if len(s.buffer) > orderWindow {
+		t.Errorf("%d packets buffered, more than the window of %d", len(s.buffer), orderWindow)
+	}
+	want := 3*orderWindow - len(s.buffer)
+	if len(delivered) != want || !slices.IsSorted(delivered) {
+		t.Errorf("delivered %d packets in order %v, want %d in order", len(delivered), slices.IsSorted(delivered), want)
+	}
+}
+
+func TestCheckOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    DeliveryMode
+		seq     uint16
+		wantErr error
+	}{
+		{"reliable within window", ReliableOrdered, orderWindow - 1, nil},
+		{"reliable beyond window", ReliableOrdered, orderWindow, ErrOrderWindow},
+		{"unreliable beyond window", UnreliableOrdered, orderWindow, nil},
+		{"sequenced", UnreliableSequenced, 40000, nil},
+		{"unordered", Reliable, 40000, nil},
+	}
+
+	c := newTestConnection(DefaultConfig())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.checkOrder(&Packet{Type: DATA, Mode: tt.mode, OrderSequence: tt.seq})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkOrder() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOrderSkipSentForFailedMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     DeliveryMode
+		wantSkip bool
+	}{
+		{"reliable ordered", ReliableOrdered, true},
+		{"reliable", Reliable, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConnection(DefaultConfig())
+			if err := c.SendOnChannel(3, []byte("lost"), tt.mode); err != nil {
+				t.Fatal(err)
+			}
+			sent := <-c.outbound
+
+			c.mu.Lock()
+			failed := c.failPacket(sent)
+			c.mu.Unlock()
+			if len(failed) != 1 || failed[0] != sent {
+				t.Fatalf("failPacket() reported %d messages, want the sent one", len(failed))
+			}
+
+			select {
+			case skip := <-c.outbound:
+				if !tt.wantSkip {
+					t.Fatalf("unexpected %d packet queued", skip.Type)
+				}
+				if skip.Type != ORDER_SKIP || skip.Mode != ReliableOrdered || skip.Channel != 3 ||
+					skip.OrderSequence != sent.OrderSequence {
+					t.Errorf("queued %+v, want an ORDER_SKIP for channel 3 order sequence %d", skip, sent.OrderSequence)
+				}
+				c.mu.Lock()
+				// A skip that fails itself is sent again rather than reported
+				if failed := c.failPacket(skip); failed != nil {
+					t.Errorf("failed ORDER_SKIP reported as %d messages", len(failed))
+				}
+				c.mu.Unlock()
+				if again := <-c.outbound; again.Type != ORDER_SKIP || again.OrderSequence != skip.OrderSequence {
+					t.Errorf("failed ORDER_SKIP not sent again")
+				}
+			default:
+				if tt.wantSkip {
+					t.Error("no ORDER_SKIP queued")
+				}
+			}
+		})
+	}
+}
+
+func TestOrderSkipReleasesStream(t *testing.T) {
+	c := newTestConnection(DefaultConfig())
+	incoming := []*Packet{
+		{Type: DATA, Sequence: 1, Mode: ReliableOrdered, OrderSequence: 1, Data: []byte("one")},
+		{Type: DATA, Sequence: 2, Mode: ReliableOrdered, OrderSequence: 2, Data: []byte("two")},
+		{Type: ORDER_SKIP, Sequence: 3, Mode: ReliableOrdered, OrderSequence: 0},
+	}
+	for _, packet := range incoming {
+		if err := c.HandleIncomingPacket(packet); err != nil {
+			t.Fatalf("HandleIncomingPacket() error = %v", err)
+		}
+	}
+
+	var got []string
+	for len(c.inbound) > 0 {
+		got = append(got, string((<-c.inbound).Data))
+	}
+	if !slices.Equal(got, []string{"one", "two"}) {
+		t.Errorf("delivered %q, want [one two]", got)
+	}
+
+	if err := c.HandleIncomingPacket(&Packet{Type: ORDER_SKIP, Sequence: 4, Mode: Reliable}); !errors.Is(err, ErrInvalidPacket) {
+		t.Errorf("unordered ORDER_SKIP: HandleIncomingPacket() error = %v, want %v", err, ErrInvalidPacket)
+	}
+}
+
+func TestInboundRefusals(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxReassemblyBytes = 1
+	c := newTestConnection(config)
+
+	incoming := []struct {
+		packet  *Packet
+		wantErr error
+	}{
+		{&Packet{Type: DATA, Sequence: 1, Mode: ReliableOrdered, OrderSequence: orderWindow}, ErrOrderWindow},
+		{fragmentPacket(1, 0, 2, []byte("chunk")), ErrReassemblyFull},
+		{&Packet{Type: DATA, Sequence: 2, Mode: UnreliableSequenced, OrderSequence: 5}, nil},
+		{&Packet{Type: DATA, Sequence: 3, Mode: UnreliableSequenced, OrderSequence: 4}, nil}, // Late, dropped
+		{&Packet{Type: DATA, Sequence: 4, Mode: ReliableOrdered, OrderSequence: 0}, nil},
+	}
+	for i, in := range incoming {
+		if err := c.HandleIncomingPacket(in.packet); !errors.Is(err, in.wantErr) {
+			t.Fatalf("packet %d: HandleIncomingPacket() error = %v, want %v", i, err, in.wantErr)
+		}
+	}
+
+	stats := c.Stats()
+	counters := []struct {
+		name string
+		got  uint64
+	}{
+		{"OrderWindowRefusals", stats.OrderWindowRefusals},
+		{"ReassemblyRefusals", stats.ReassemblyRefusals},
+		{"LateOrdered", stats.LateOrdered},
+	}
+	for _, counter := range counters {
+		if counter.got != 1 {
+			t.Errorf("%s = %d, want 1", counter.name, counter.got)
+		}
+	}
+	if delivered := len(c.inbound); delivered != 2 {
+		t.Errorf("%d packets delivered, want 2", delivered)
+	}
+}
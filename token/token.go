@@ -0,0 +1,212 @@
+// Package token issues and verifies connect tokens, which let a separate
+// authentication service (such as a matchmaker) decide who may connect to a
+// game server. The service and the server share a secret; the client only
+// passes the sealed token along and cannot read or alter it.
+package token
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const (
+	SecretSize     = 32   // Size of the secret shared by the issuer and the servers
+	SessionKeySize = 32   // Size of the key the client proves possession of
+	MaxSize        = 1200 // Largest sealed token, which fits a handshake packet of the default MaxPacketSize
+	version        = 1
+	nonceSize      = 12
+	maxAddrs       = 32
+	maxAddrLen     = 255
+	maxUserData    = 1024
+)
+
+// associatedData binds sealed tokens to this format
+var associatedData = []byte("rudp connect token v1")
+
+var (
+	ErrInvalidSecret  = errors.New("token secret must be 32 bytes")
+	ErrInvalidToken   = errors.New("invalid connect token")
+	ErrExpired        = errors.New("connect token expired")
+	ErrWrongServer    = errors.New("connect token not valid for this server")
+	ErrTokenTooLarge  = errors.New("connect token fields exceed their maximum size")
+	ErrInvalidSession = errors.New("session key must be 32 bytes")
+)
+
+// Token holds what the issuer grants a client
+type Token struct {
+	UserID      uint64    // Identity of the user, assigned by the issuer
+	ServerAddrs []string  // Addresses of the servers the token may be used on
+	ExpiresAt   time.Time // Time after which servers reject the token
+	SessionKey  []byte    // Secret handed to both the client and, inside the token, the server
+	UserData    []byte    // Application data delivered to the server, up to 1024 bytes
+}
+
+// New creates a token for a user with a random session key
+func New(userID uint64, serverAddrs []string, ttl time.Duration, userData []byte) (*Token, error) {
+	key := make([]byte, SessionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &Token{
+		UserID:      userID,
+		ServerAddrs: serverAddrs,
+		ExpiresAt:   time.Now().Add(ttl),
+		SessionKey:  key,
+		UserData:    userData,
+	}, nil
+}
+
+// Seal encrypts the token with the shared secret. The result is handed to the
+// client together with SessionKey, over a channel the issuer trusts.
+func (t *Token) Seal(secret []byte) ([]byte, error) {
+	if len(t.SessionKey) != SessionKeySize {
+		return nil, ErrInvalidSession
+	}
+	if len(t.ServerAddrs) > maxAddrs || len(t.UserData) > maxUserData {
+		return nil, ErrTokenTooLarge
+	}
+
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := []byte{version}
+	buf = binary.LittleEndian.AppendUint64(buf, t.UserID)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(t.ExpiresAt.Unix()))
+	buf = append(buf, t.SessionKey...)
+	buf = append(buf, byte(len(t.ServerAddrs)))
+	for _, addr := range t.ServerAddrs {
+		if len(addr) > maxAddrLen {
+			return nil, ErrTokenTooLarge
+		}
+		buf = append(buf, byte(len(addr)))
+		buf = append(buf, addr...)
+	}
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(t.UserData)))
+	buf = append(buf, t.UserData...)
+
+	if nonceSize+len(buf)+aead.Overhead() > MaxSize {
+		return nil, ErrTokenTooLarge
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, buf, associatedData), nil
+}
+
+// Open decrypts a sealed token with the shared secret and checks that it has not expired
+func Open(sealed []byte, secret []byte, now time.Time) (*Token, error) {
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < nonceSize || len(sealed) > MaxSize {
+		return nil, ErrInvalidToken
+	}
+
+	buf, err := aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], associatedData)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	t, err := parse(buf)
+	if err != nil {
+		return nil, err
+	}
+	if now.After(t.ExpiresAt) {
+		return nil, ErrExpired
+	}
+	return t, nil
+}
+
+// AllowsServer reports whether the token may be used on the server with the given address
+func (t *Token) AllowsServer(addr string) bool {
+	for _, a := range t.ServerAddrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// parse decodes the plaintext of a token
+func parse(buf []byte) (*Token, error) {
+	r := reader{buf: buf}
+	if r.byte() != version {
+		return nil, ErrInvalidToken
+	}
+
+	t := &Token{}
+	t.UserID = r.uint64()
+	t.ExpiresAt = time.Unix(int64(r.uint64()), 0)
+	t.SessionKey = r.bytes(SessionKeySize)
+	for n := int(r.byte()); n > 0; n-- {
+		t.ServerAddrs = append(t.ServerAddrs, string(r.bytes(int(r.byte()))))
+	}
+	t.UserData = r.bytes(int(r.uint16()))
+
+	if r.err || len(r.buf) != 0 {
+		return nil, ErrInvalidToken
+	}
+	return t, nil
+}
+
+// newAEAD creates the cipher sealing tokens
+func newAEAD(secret []byte) (cipher.AEAD, error) {
+	if len(secret) != SecretSize {
+		return nil, ErrInvalidSecret
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// reader decodes fields, remembering whether it ran out of data
+type reader struct {
+	buf []byte
+	err bool
+}
+
+func (r *reader) bytes(n int) []byte {
+	if n > len(r.buf) {
+		r.err = true
+		r.buf = nil
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) byte() byte {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *reader) uint16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (r *reader) uint64() uint64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
@@ -0,0 +1,122 @@
+package token
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = bytes.Repeat([]byte{7}, SecretSize)
+
+// newTestToken creates a token valid for a minute from now
+func newTestToken(t *testing.T, userData []byte) *Token {
+	t.Helper()
+	tok, err := New(42, []string{"127.0.0.1:8080", "game.example.com:8080"}, time.Minute, userData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+func TestSealOpen(t *testing.T) {
+	tok := newTestToken(t, []byte("player"))
+	sealed, err := tok.Seal(testSecret)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+
+	got, err := Open(sealed, testSecret, time.Now())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if got.UserID != tok.UserID || got.ExpiresAt.Unix() != tok.ExpiresAt.Unix() ||
+		!bytes.Equal(got.SessionKey, tok.SessionKey) || !bytes.Equal(got.UserData, tok.UserData) ||
+		strings.Join(got.ServerAddrs, ",") != strings.Join(tok.ServerAddrs, ",") {
+		t.Errorf("Open() = %+v, want %+v", got, tok)
+	}
+	if !got.AllowsServer("game.example.com:8080") || got.AllowsServer("other.example.com:8080") {
+		t.Errorf("AllowsServer() does not match ServerAddrs %v", got.ServerAddrs)
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	tok := newTestToken(t, nil)
+	sealed, err := tok.Seal(testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := func(modify func(buf []byte) []byte) []byte {
+		return modify(append([]byte{}, sealed...))
+	}
+
+	tests := []struct {
+		name    string
+		sealed  []byte
+		secret  []byte
+		now     time.Time
+		wantErr error
+	}{
+		{"expired", sealed, testSecret, tok.ExpiresAt.Add(time.Second), ErrExpired},
+		{"wrong secret", sealed, bytes.Repeat([]byte{8}, SecretSize), time.Now(), ErrInvalidToken},
+		{"invalid secret", sealed, testSecret[:16], time.Now(), ErrInvalidSecret},
+		{"tampered nonce", modified(func(buf []byte) []byte {
+			buf[0] ^= 0x01
+			return buf
+		}), testSecret, time.Now(), ErrInvalidToken},
+		{"tampered ciphertext", modified(func(buf []byte) []byte {
+			buf[nonceSize+1] ^= 0x01
+			return buf
+		}), testSecret, time.Now(), ErrInvalidToken},
+		{"truncated", sealed[:len(sealed)-1], testSecret, time.Now(), ErrInvalidToken},
+		{"shorter than nonce", sealed[:nonceSize-1], testSecret, time.Now(), ErrInvalidToken},
+		{"over MaxSize", append(append([]byte{}, sealed...), make([]byte, MaxSize)...), testSecret, time.Now(), ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Open(tt.sealed, tt.secret, tt.now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Open() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != nil {
+				t.Errorf("Open() returned a token along with an error")
+			}
+		})
+	}
+}
+
+func TestSealRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(tok *Token)
+		secret  []byte
+		wantErr error
+	}{
+		{"largest user data", func(tok *Token) { tok.UserData = make([]byte, maxUserData) }, testSecret, nil},
+		{"user data too large", func(tok *Token) { tok.UserData = make([]byte, maxUserData+1) }, testSecret, ErrTokenTooLarge},
+		{"too many addresses", func(tok *Token) { tok.ServerAddrs = make([]string, maxAddrs+1) }, testSecret, ErrTokenTooLarge},
+		{"address too long", func(tok *Token) { tok.ServerAddrs = []string{strings.Repeat("a", maxAddrLen+1)} }, testSecret, ErrTokenTooLarge},
+		{"sealed over MaxSize", func(tok *Token) {
+			tok.UserData = make([]byte, maxUserData)
+			tok.ServerAddrs = []string{strings.Repeat("a", maxAddrLen)}
+		}, testSecret, ErrTokenTooLarge},
+		{"invalid session key", func(tok *Token) { tok.SessionKey = tok.SessionKey[:16] }, testSecret, ErrInvalidSession},
+		{"invalid secret", func(tok *Token) {}, testSecret[:16], ErrInvalidSecret},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tok := newTestToken(t, nil)
+			tt.modify(tok)
+			sealed, err := tok.Seal(tt.secret)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Seal() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(sealed) > MaxSize {
+				t.Errorf("sealed token is %d bytes, over MaxSize %d", len(sealed), MaxSize)
+			}
+		})
+	}
+}